@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// AllergenSource records where an allergen was found in the OFF product
+type AllergenSource struct {
+	Field string `json:"field"`         // e.g. "allergens_tags", "allergens" or "ingredients_tags"
+	Tag   string `json:"tag,omitempty"` // Original tag or free-text value
+}
+
+// AllergenSet collects standardized allergens without duplicates, together with their sources
+type AllergenSet struct {
+	sources map[string][]AllergenSource
+}
+
+func NewAllergenSet() *AllergenSet {
+	return &AllergenSet{sources: make(map[string][]AllergenSource)}
+}
+
+// Add records an allergen, ignoring repeated sources
+func (s *AllergenSet) Add(allergen string, source AllergenSource) {
+	for _, existing := range s.sources[allergen] {
+		if existing == source {
+			return
+		}
+	}
+	s.sources[allergen] = append(s.sources[allergen], source)
+}
+
+// Values returns the allergens in sorted order
+func (s *AllergenSet) Values() []string {
+	values := make([]string, 0, len(s.sources))
+	for allergen := range s.sources {
+		values = append(values, allergen)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Provenance returns the sources of every allergen in the set
+func (s *AllergenSet) Provenance() map[string][]AllergenSource {
+	return s.sources
+}
+
+// Merge adds every allergen and source of another set
+func (s *AllergenSet) Merge(other *AllergenSet) {
+	for allergen, sources := range other.sources {
+		for _, source := range sources {
+			s.Add(allergen, source)
+		}
+	}
+}
+
+// extractDeclaredAllergens normalizes allergens_tags, or the free-text allergens field when there are no tags.
+// Values that cannot be mapped are collected in the unmapped set.
+func extractDeclaredAllergens(product OpenFoodFactsProduct) (allergens *AllergenSet, unmapped *AllergenSet) {
+	return normalizeAllergenField(product.AllergensTags, "allergens_tags", product.Allergens, "allergens", product.Lang)
+}
+
+// extractTraceAllergens normalizes the "may contain" traces_tags, or the free-text traces field when there are no tags
+func extractTraceAllergens(product OpenFoodFactsProduct) (traces *AllergenSet, unmapped *AllergenSet) {
+	return normalizeAllergenField(product.TracesTags, "traces_tags", product.Traces, "traces", product.Lang)
+}
+
+// normalizeAllergenField normalizes the tags of a field, or its free text when there are no tags. Free-text values
+// without a language prefix are written in the product lang, e.g. "lait" on a French product is read as "fr:lait".
+func normalizeAllergenField(tags []string, tagsField string, text string, textField string, lang string) (allergens *AllergenSet, unmapped *AllergenSet) {
+	allergens = NewAllergenSet()
+	unmapped = NewAllergenSet()
+
+	field := tagsField
+	values := tags
+	if len(values) == 0 && text != "" {
+		field = textField
+		values = strings.Split(text, ",")
+	}
+	lang = strings.ToLower(strings.TrimSpace(lang))
+
+	for _, value := range values {
+		source := AllergenSource{Field: field, Tag: strings.TrimSpace(value)}
+		if field == textField && lang != "" && ParseTag(value).Lang == "" {
+			value = lang + ":" + strings.TrimSpace(value)
+		}
+		normalized, ok := normalizeAllergen(value)
+		if ok {
+			allergens.Add(normalized, source)
+		} else if normalized != "" {
+			unmapped.Add(normalized, source)
+		}
+	}
+
+	return allergens, unmapped
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractDeclaredAllergensFreeText(t *testing.T) {
+	saved := AllergenSynonyms
+	defer func() { AllergenSynonyms = saved }()
+	AllergenSynonyms = map[string]string{"fr:lait": "milk", "fr:oeufs": "eggs"}
+
+	tests := []struct {
+		name     string
+		product  OpenFoodFactsProduct
+		want     []string
+		unmapped []string
+	}{
+		{"free text in the product lang", OpenFoodFactsProduct{Lang: "fr", Allergens: "Lait, œufs"}, []string{"eggs", "milk"}, []string{}},
+		{"prefixed free text", OpenFoodFactsProduct{Lang: "de", Allergens: "fr:lait, en:gluten"}, []string{"gluten", "milk"}, []string{}},
+		{"unmapped free text keeps its lang", OpenFoodFactsProduct{Lang: "fr", Allergens: "lait, céleri-rave"}, []string{"milk"}, []string{"fr:celeri-rave"}},
+		{"free text without lang", OpenFoodFactsProduct{Allergens: "milk"}, []string{"milk"}, []string{}},
+		{"tags are not prefixed", OpenFoodFactsProduct{Lang: "fr", AllergensTags: []string{"lait"}}, []string{}, []string{"lait"}},
+	}
+	for _, tt := range tests {
+		allergens, unmapped := extractDeclaredAllergens(tt.product)
+		if !reflect.DeepEqual(allergens.Values(), tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, allergens.Values(), tt.want)
+		}
+		if !reflect.DeepEqual(unmapped.Values(), tt.unmapped) {
+			t.Errorf("%s: unmapped got %v, want %v", tt.name, unmapped.Values(), tt.unmapped)
+		}
+	}
+}
+
+func TestProcessProductUnmappedTraces(t *testing.T) {
+	product := OpenFoodFactsProduct{
+		ID:            "1",
+		Code:          "1",
+		ProductName:   "Biscuits",
+		AllergensTags: []string{"en:milk", "en:unknown-allergen"},
+		TracesTags:    []string{"en:nuts", "en:unknown-trace"},
+	}
+	foodItem, err := ProcessProduct(product)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(foodItem.UnmappedAllergens, []string{"en:unknown-allergen"}) {
+		t.Errorf("unmapped_allergens: got %v", foodItem.UnmappedAllergens)
+	}
+	if !reflect.DeepEqual(foodItem.UnmappedTraces, []string{"en:unknown-trace"}) {
+		t.Errorf("unmapped_traces: got %v", foodItem.UnmappedTraces)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+)
+
+// FoodDensity describes how volumes and household measures of the products in an OFF category convert to grams
+type FoodDensity struct {
+	Category   string  // OFF category tag, e.g. "en:milks"
+	Density    float64 // Grams per ml
+	Cup        float64 // Grams per cup, 0 to use the density
+	Tablespoon float64 // Grams per tablespoon, 0 to use the density
+	Teaspoon   float64 // Grams per teaspoon, 0 to use the density
+}
+
+// Volumes of the household measures in ml
+const ML_PER_CUP = 240.0
+const ML_PER_TABLESPOON = 15.0
+const ML_PER_TEASPOON = 5.0
+const ML_PER_FL_OZ = 29.5735
+
+// Weight sources recorded in ServingSize.WeightSource
+const (
+	WeightSourceDeclared  = "declared"
+	WeightSourceEstimated = "estimated"
+)
+
+// DefaultFoodDensity is used for products without a known category, it treats 1 ml as 1 g
+var DefaultFoodDensity = FoodDensity{Density: 1}
+
+// FoodDensities lists the densities and household measures per category, more specific categories first.
+// Powders and pieces don't pack like liquids, so their household measures are given in grams.
+var FoodDensities = []FoodDensity{
+	// Liquids
+	{Category: "en:olive-oils", Density: 0.91},
+	{Category: "en:vegetable-oils", Density: 0.92},
+	{Category: "en:oils", Density: 0.92},
+	{Category: "en:honeys", Density: 1.42},
+	{Category: "en:syrups", Density: 1.33},
+	{Category: "en:plant-based-milk-alternatives", Density: 1.03},
+	{Category: "en:plant-milks", Density: 1.03},
+	{Category: "en:milks", Density: 1.03},
+	{Category: "en:creams", Density: 1.01},
+	{Category: "en:drinkable-yogurts", Density: 1.05},
+	{Category: "en:fruit-juices", Density: 1.05},
+	{Category: "en:nectars", Density: 1.06},
+	{Category: "en:sodas", Density: 1.04},
+	{Category: "en:energy-drinks", Density: 1.04},
+	{Category: "en:beers", Density: 1.01},
+	{Category: "en:wines", Density: 0.99},
+	{Category: "en:spirits", Density: 0.95},
+	{Category: "en:waters", Density: 1.0},
+	{Category: "en:vinegars", Density: 1.01},
+	{Category: "en:soups", Density: 1.03},
+
+	// Spreads and pastes
+	{Category: "en:peanut-butters", Density: 1.08, Tablespoon: 16, Teaspoon: 5.3},
+	{Category: "en:nut-butters", Density: 1.08, Tablespoon: 16, Teaspoon: 5.3},
+	{Category: "en:butters", Density: 0.91, Cup: 227, Tablespoon: 14.2, Teaspoon: 4.7},
+	{Category: "en:jams", Density: 1.33, Tablespoon: 20, Teaspoon: 7},
+	{Category: "en:mayonnaises", Density: 0.95, Tablespoon: 14, Teaspoon: 4.6},
+	{Category: "en:sauces", Density: 1.1},
+
+	// Powders, grains and pieces
+	{Category: "en:flours", Density: 0.53, Cup: 125, Tablespoon: 7.8, Teaspoon: 2.6},
+	{Category: "en:cocoa-powders", Density: 0.42, Cup: 86, Tablespoon: 5.4, Teaspoon: 1.8},
+	{Category: "en:protein-powders", Density: 0.4, Cup: 96, Tablespoon: 6, Teaspoon: 2},
+	{Category: "en:powdered-sugars", Density: 0.56, Cup: 120, Tablespoon: 7.5, Teaspoon: 2.5},
+	{Category: "en:sugars", Density: 0.85, Cup: 200, Tablespoon: 12.5, Teaspoon: 4.2},
+	{Category: "en:salts", Density: 1.2, Cup: 292, Tablespoon: 18, Teaspoon: 6},
+	{Category: "en:rolled-oats", Density: 0.34, Cup: 81, Tablespoon: 5},
+	{Category: "en:breakfast-cereals", Density: 0.15, Cup: 36, Tablespoon: 2.3},
+	{Category: "en:rices", Density: 0.78, Cup: 185, Tablespoon: 11.6},
+	{Category: "en:pastas", Density: 0.45, Cup: 105},
+	{Category: "en:nuts", Density: 0.6, Cup: 140, Tablespoon: 8.8},
+	{Category: "en:seeds", Density: 0.6, Cup: 140, Tablespoon: 9, Teaspoon: 3},
+	{Category: "en:grated-cheeses", Density: 0.42, Cup: 100, Tablespoon: 6.3},
+	{Category: "en:coffees", Density: 0.38, Tablespoon: 5, Teaspoon: 1.8},
+}
+
+// foodDensityForProduct returns the first listed category the product belongs to, or DefaultFoodDensity
+func foodDensityForProduct(product OpenFoodFactsProduct) (FoodDensity, bool) {
+	categories := make(map[string]bool, len(product.CategoriesTags))
+	for _, category := range product.CategoriesTags {
+		categories[strings.ToLower(strings.TrimSpace(category))] = true
+	}
+
+	for _, foodDensity := range FoodDensities {
+		if categories[foodDensity.Category] {
+			return foodDensity, true
+		}
+	}
+
+	return DefaultFoodDensity, false
+}
+
+// householdMeasureGrams returns the grams of one cup, tablespoon or teaspoon, using the density when the
+// category has no specific weight for the measure
+func (f FoodDensity) householdMeasureGrams(unit string) float64 {
+	switch strings.ToLower(unit) {
+	case "cup", "cups":
+		if f.Cup > 0 {
+			return f.Cup
+		}
+		return ML_PER_CUP * f.Density
+	case "tbsp", "tablespoon", "tablespoons":
+		if f.Tablespoon > 0 {
+			return f.Tablespoon
+		}
+		return ML_PER_TABLESPOON * f.Density
+	case "tsp", "teaspoon", "teaspoons":
+		if f.Teaspoon > 0 {
+			return f.Teaspoon
+		}
+		return ML_PER_TEASPOON * f.Density
+	default:
+		return 0
+	}
+}
+
+// isVolumeBasis reports whether OFF nutrition_data_per refers to 100 ml instead of 100 g
+func isVolumeBasis(nutritionDataPer string) bool {
+	return strings.EqualFold(strings.ReplaceAll(strings.TrimSpace(nutritionDataPer), " ", ""), "100ml")
+}
+
+// isVolumeUnit reports whether a unit measures volume, so its weight depends on the density
+func isVolumeUnit(unit string) bool {
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "ml", "cl", "dl", "l", "liter", "litre", "liters", "litres", "fl oz", "fl. oz", "fl.oz", "fl_oz",
+		"cup", "cups", "tbsp", "tablespoon", "tablespoons", "tsp", "teaspoon", "teaspoons":
+		return true
+	default:
+		return false
+	}
+}
+
+// weightSourceForUnit returns whether a weight converted from the unit was declared or estimated
+func weightSourceForUnit(unit string) string {
+	if isVolumeUnit(unit) {
+		return WeightSourceEstimated
+	}
+	return WeightSourceDeclared
+}
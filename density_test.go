@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewReferenceServing(t *testing.T) {
+	honey := FoodDensity{Category: "en:honeys", Density: 1.42}
+	tests := []struct {
+		nutritionDataPer string
+		food             FoodDensity
+		unit             string
+		unitID           string
+		weightInGrams    float64
+		weightSource     string
+	}{
+		{"100g", honey, "g", UnitGram, 100, WeightSourceDeclared},
+		{"", honey, "g", UnitGram, 100, WeightSourceDeclared},
+		{"100ml", honey, "ml", UnitMilliliter, 142, WeightSourceEstimated},
+		{"100 ML", honey, "ml", UnitMilliliter, 142, WeightSourceEstimated},
+		{"100ml", DefaultFoodDensity, "ml", UnitMilliliter, 100, WeightSourceEstimated},
+	}
+	for _, tt := range tests {
+		ss := newReferenceServing(tt.nutritionDataPer, tt.food)
+		if ss.Quantity != 100 || ss.MeasurementUnit != tt.unit || ss.UnitID != tt.unitID || ss.WeightSource != tt.weightSource ||
+			math.Abs(ss.WeightInGrams-tt.weightInGrams) > 1e-9 {
+			t.Errorf("%q with density %g: got %g %s (%s) of %g g %s, want 100 %s (%s) of %g g %s", tt.nutritionDataPer, tt.food.Density,
+				ss.Quantity, ss.MeasurementUnit, ss.UnitID, ss.WeightInGrams, ss.WeightSource, tt.unit, tt.unitID, tt.weightInGrams, tt.weightSource)
+		}
+	}
+}
+
+func TestFoodDensityForProduct(t *testing.T) {
+	tests := []struct {
+		categories []string
+		want       string // Category of the density, empty for DefaultFoodDensity
+	}{
+		{[]string{"en:spreads", "en:sweet-spreads", "en:honeys"}, "en:honeys"},
+		{[]string{" EN:Olive-Oils "}, "en:olive-oils"},
+		// The more specific category listed first in FoodDensities wins
+		{[]string{"en:oils", "en:olive-oils"}, "en:olive-oils"},
+		{[]string{"en:nuts", "en:nut-butters", "en:peanut-butters"}, "en:peanut-butters"},
+		{[]string{"en:snacks"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		food, ok := foodDensityForProduct(OpenFoodFactsProduct{CategoriesTags: tt.categories})
+		if food.Category != tt.want || ok != (tt.want != "") {
+			t.Errorf("%v: got %q %v, want %q", tt.categories, food.Category, ok, tt.want)
+		}
+		if !ok && food != DefaultFoodDensity {
+			t.Errorf("%v: got %+v, want DefaultFoodDensity", tt.categories, food)
+		}
+	}
+}
+
+func TestHouseholdMeasureGrams(t *testing.T) {
+	flour := FoodDensity{Category: "en:flours", Density: 0.53, Cup: 125, Tablespoon: 7.8, Teaspoon: 2.6}
+	honey := FoodDensity{Category: "en:honeys", Density: 1.42}
+	rolledOats := FoodDensity{Category: "en:rolled-oats", Density: 0.34, Cup: 81, Tablespoon: 5}
+	tests := []struct {
+		food FoodDensity
+		unit string
+		want float64
+	}{
+		// Specific weights of the category
+		{flour, "cup", 125},
+		{flour, "Tablespoons", 7.8},
+		{flour, "tsp", 2.6},
+		// Measure volume times the density
+		{honey, "cups", ML_PER_CUP * 1.42},
+		{honey, "tbsp", ML_PER_TABLESPOON * 1.42},
+		{rolledOats, "teaspoon", ML_PER_TEASPOON * 0.34},
+		{DefaultFoodDensity, "cup", ML_PER_CUP},
+		// Not a household measure
+		{flour, "slice", 0},
+		{honey, "ml", 0},
+	}
+	for _, tt := range tests {
+		if got := tt.food.householdMeasureGrams(tt.unit); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s %s: got %g g, want %g", tt.food.Category, tt.unit, got, tt.want)
+		}
+	}
+}
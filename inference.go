@@ -0,0 +1,324 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Confidence levels of an ingredient allergen match
+const (
+	ConfidenceHigh   = "high"
+	ConfidenceMedium = "medium"
+	ConfidenceLow    = "low"
+)
+
+// IngredientAllergenMatch explains why an allergen was inferred from the ingredients
+type IngredientAllergenMatch struct {
+	Allergen   string `json:"allergen"`
+	Ingredient string `json:"ingredient"`       // Listed ingredient tag that triggered the match
+	Parent     string `json:"parent,omitempty"` // Parent tag that names the allergen, for hierarchy matches
+	Rule       string `json:"rule"`             // "exact", "hierarchy", "derivative" or "substring"
+	Confidence string `json:"confidence"`
+}
+
+// DerivativeRule maps an ingredient term that does not name its allergen, e.g. whey -> milk
+type DerivativeRule struct {
+	Term     string // Normalized ingredient term, words joined with hyphens
+	Allergen string
+}
+
+// IngredientDerivativeRules are the curated ingredient terms that imply an allergen
+var IngredientDerivativeRules = []DerivativeRule{
+	// Milk
+	{"whey", "milk"},
+	{"lactoserum", "milk"},
+	{"casein", "milk"},
+	{"caseinate", "milk"},
+	{"sodium-caseinate", "milk"},
+	{"calcium-caseinate", "milk"},
+	{"lactose", "milk"},
+	{"lactalbumin", "milk"},
+	{"butter", "milk"},
+	{"butterfat", "milk"},
+	{"buttermilk", "milk"},
+	{"ghee", "milk"},
+	{"cream", "milk"},
+	{"cheese", "milk"},
+	{"yogurt", "milk"},
+	{"yoghurt", "milk"},
+	{"kefir", "milk"},
+	{"cheese-curd", "milk"},
+	{"paneer", "milk"},
+	{"quark", "milk"},
+	{"milkfat", "milk"},
+
+	// Wheat
+	{"semolina", "wheat"},
+	{"durum", "wheat"},
+	{"spelt", "wheat"},
+	{"couscous", "wheat"},
+	{"bulgur", "wheat"},
+	{"seitan", "wheat"},
+	{"farro", "wheat"},
+	{"kamut", "wheat"},
+	{"einkorn", "wheat"},
+	{"emmer", "wheat"},
+
+	// Eggs
+	{"albumin", "eggs"},
+	{"ovalbumin", "eggs"},
+	{"egg-yolk", "eggs"},
+	{"egg-white", "eggs"},
+	{"mayonnaise", "eggs"},
+	{"lysozyme", "eggs"},
+
+	// Soy
+	{"tofu", "soy"},
+	{"tempeh", "soy"},
+	{"miso", "soy"},
+	{"edamame", "soy"},
+	{"soya", "soy"},
+
+	// Sesame
+	{"tahini", "sesame"},
+	{"tahina", "sesame"},
+
+	// Fish and shellfish
+	{"surimi", "fish"},
+	{"prawn", "shrimp"},
+
+	// Tree nuts, the specific nut is also inferred from AllergenMap
+	{"nut", "tree_nuts"},
+	{"tree-nut", "tree_nuts"},
+	{"almond", "tree_nuts"},
+	{"brazil-nut", "tree_nuts"},
+	{"cashew", "tree_nuts"},
+	{"hazelnut", "tree_nuts"},
+	{"macadamia", "tree_nuts"},
+	{"macadamia-nut", "tree_nuts"},
+	{"pecan", "tree_nuts"},
+	{"pecan-nut", "tree_nuts"},
+	{"pistachio", "tree_nuts"},
+	{"walnut", "tree_nuts"},
+}
+
+// IngredientAllergenExclusions are ingredient terms that contain an allergen word without containing the allergen.
+// Every term is also excluded when followed by "free", e.g. "gluten-free" or "lactose-free", see isExcludedIngredient.
+var IngredientAllergenExclusions = map[string][]string{
+	"milk":   {"coconut-milk", "almond-milk", "oat-milk", "rice-milk", "soy-milk", "soya-milk", "cashew-milk", "milk-thistle", "dairy-free"},
+	"butter": {"peanut-butter", "cocoa-butter", "shea-butter", "almond-butter", "nut-butter", "butternut"},
+	"cream":  {"cream-of-tartar", "coconut-cream"},
+	"cheese": {"vegan-cheese"},
+	"eggs":   {"eggplant"},
+	"wheat":  {"buckwheat"},
+	"nut":    {"tiger-nut", "ground-nut", "earth-nut"},
+}
+
+// IngredientsTaxonomy is the optional OFF ingredients taxonomy, used to walk ingredient parents
+var IngredientsTaxonomy *Taxonomy
+
+// inferIngredientAllergens walks ingredients_tags and ingredients_hierarchy and returns every inferred
+// allergen together with its strongest match. Low confidence matches are only returned as matches.
+func inferIngredientAllergens(product OpenFoodFactsProduct) (*AllergenSet, []IngredientAllergenMatch) {
+	allergens := NewAllergenSet()
+	best := make(map[string]IngredientAllergenMatch)
+
+	record := func(match IngredientAllergenMatch, field string) {
+		if match.Confidence != ConfidenceLow {
+			allergens.Add(match.Allergen, AllergenSource{Field: field, Tag: match.Ingredient})
+		}
+		if current, ok := best[match.Allergen]; !ok || confidenceRank(match.Confidence) > confidenceRank(current.Confidence) {
+			best[match.Allergen] = match
+		}
+	}
+
+	tagged := make(map[string]bool)
+	for _, ingredientTag := range product.IngredientsTags {
+		tagged[ingredientTag] = true
+		for _, match := range matchIngredientTag(ingredientTag) {
+			record(match, "ingredients_tags")
+		}
+
+		// Parents from the taxonomy, for exports without ingredients_hierarchy
+		if IngredientsTaxonomy != nil {
+			if id, ok := IngredientsTaxonomy.Resolve(ingredientTag); ok {
+				for _, ancestor := range IngredientsTaxonomy.Ancestors(id) {
+					if allergen := extractIngredientAllergen(ancestor); allergen != "" {
+						record(IngredientAllergenMatch{Allergen: allergen, Ingredient: ingredientTag, Parent: ancestor, Rule: "hierarchy", Confidence: ConfidenceHigh}, "ingredients_tags")
+					}
+				}
+			}
+		}
+	}
+
+	// The hierarchy lists every ingredient together with its parents, e.g. en:skimmed-milk-powder -> en:milk.
+	// Allergens named by a parent are credited to the listed ingredient below it.
+	for _, ingredientTag := range product.IngredientsHierarchy {
+		if tagged[ingredientTag] {
+			continue
+		}
+		if allergen := extractIngredientAllergen(ingredientTag); allergen != "" {
+			match := IngredientAllergenMatch{Allergen: allergen, Ingredient: ingredientTag, Rule: "hierarchy", Confidence: ConfidenceHigh}
+			if ingredient, ok := hierarchyIngredient(ingredientTag, product.IngredientsTags); ok {
+				match.Ingredient = ingredient
+				match.Parent = ingredientTag
+			}
+			record(match, "ingredients_hierarchy")
+		}
+	}
+
+	matches := make([]IngredientAllergenMatch, 0, len(best))
+	for _, match := range best {
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Allergen < matches[j].Allergen
+	})
+
+	return allergens, matches
+}
+
+// hierarchyIngredient returns the listed ingredient below a parent of ingredients_hierarchy, found with the
+// taxonomy or, without it, as the first listed ingredient whose name contains the parent, e.g. en:milk in
+// en:skimmed-milk-powder
+func hierarchyIngredient(parent string, ingredientTags []string) (string, bool) {
+	if IngredientsTaxonomy != nil {
+		if parentID, ok := IngredientsTaxonomy.Resolve(parent); ok {
+			for _, ingredientTag := range ingredientTags {
+				id, ok := IngredientsTaxonomy.Resolve(ingredientTag)
+				if !ok {
+					continue
+				}
+				for _, ancestor := range IngredientsTaxonomy.Ancestors(id) {
+					if ancestor == parentID {
+						return ingredientTag, true
+					}
+				}
+			}
+		}
+	}
+
+	parentTag := ParseTag(parent)
+	parentValue := normalizeTaxonomyName(parentTag.Value)
+	for _, ingredientTag := range ingredientTags {
+		tag := ParseTag(ingredientTag)
+		if tag.Lang != parentTag.Lang {
+			continue
+		}
+		value := normalizeTaxonomyName(tag.Value)
+		for _, form := range termForms(parentValue) {
+			if containsTerm(value, form) {
+				return ingredientTag, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchIngredientTag applies the exact, derivative and substring rules to a single ingredient tag
+func matchIngredientTag(ingredientTag string) []IngredientAllergenMatch {
+	found := make(map[string]IngredientAllergenMatch)
+	add := func(allergen string, rule string, confidence string) {
+		if current, ok := found[allergen]; !ok || confidenceRank(confidence) > confidenceRank(current.Confidence) {
+			found[allergen] = IngredientAllergenMatch{Allergen: allergen, Ingredient: ingredientTag, Rule: rule, Confidence: confidence}
+		}
+	}
+
+	if allergen := extractIngredientAllergen(ingredientTag); allergen != "" {
+		add(allergen, "exact", ConfidenceHigh)
+	}
+
+	// The curated rules and allergen names are English, other languages only match through the taxonomy
+	tag := ParseTag(ingredientTag)
+	value := normalizeTaxonomyName(tag.Value)
+	if (tag.Lang == "" || tag.Lang == "en") && value != "" {
+		for _, rule := range IngredientDerivativeRules {
+			if isExcludedIngredient(value, rule.Term) || isExcludedIngredient(value, rule.Allergen) {
+				continue
+			}
+			for _, form := range termForms(rule.Term) {
+				if value == form {
+					add(rule.Allergen, "derivative", ConfidenceHigh)
+				} else if containsTerm(value, form) {
+					add(rule.Allergen, "derivative", ConfidenceMedium)
+				}
+			}
+		}
+
+		for key, allergen := range AllergenMap {
+			term := normalizeTaxonomyName(key)
+			if isExcludedIngredient(value, allergen) || isExcludedIngredient(value, term) {
+				continue
+			}
+			for _, form := range termForms(term) {
+				if containsTerm(value, form) {
+					add(allergen, "substring", ConfidenceMedium)
+				} else if len(form) >= 5 && strings.Contains(value, form) {
+					// e.g. "wheatflour" written as a single word
+					add(allergen, "substring", ConfidenceLow)
+				}
+			}
+		}
+	}
+
+	// Keep the output stable, AllergenMap iteration order is random
+	matches := make([]IngredientAllergenMatch, 0, len(found))
+	for _, match := range found {
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Allergen < matches[j].Allergen
+	})
+
+	return matches
+}
+
+// termForms returns the singular and plural forms of a hyphenated term, e.g. "hazelnut" and "hazelnuts"
+func termForms(term string) []string {
+	switch {
+	case strings.HasSuffix(term, "ies") && len(term) > 4:
+		return []string{term, strings.TrimSuffix(term, "ies") + "y"}
+	case strings.HasSuffix(term, "s") && !strings.HasSuffix(term, "ss") && len(term) > 3:
+		return []string{term, strings.TrimSuffix(term, "s")}
+	case strings.HasSuffix(term, "y") && len(term) > 3 && !strings.ContainsRune("aeiou", rune(term[len(term)-2])):
+		return []string{term, strings.TrimSuffix(term, "y") + "ies"}
+	default:
+		return []string{term, term + "s"}
+	}
+}
+
+// containsTerm reports whether the hyphenated term appears as whole words in the hyphenated value
+func containsTerm(value string, term string) bool {
+	return strings.Contains("-"+value+"-", "-"+term+"-")
+}
+
+// isExcludedIngredient reports whether the value names something else than the term, e.g. "peanut-butter" for
+// "butter", or says it is free of it, e.g. "gluten-free-oat-flakes" for "gluten"
+func isExcludedIngredient(value string, term string) bool {
+	for _, form := range termForms(term) {
+		if containsTerm(value, form+"-free") {
+			return true
+		}
+		for _, exclusion := range IngredientAllergenExclusions[form] {
+			for _, exclusionForm := range termForms(exclusion) {
+				if containsTerm(value, exclusionForm) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func confidenceRank(confidence string) int {
+	switch confidence {
+	case ConfidenceHigh:
+		return 3
+	case ConfidenceMedium:
+		return 2
+	case ConfidenceLow:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchIngredientTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want []string // Allergen:rule:confidence of every match
+	}{
+		{"en:milk", []string{"milk:exact:high"}},
+		{"en:whey", []string{"milk:derivative:high"}},
+		{"en:whey-powder", []string{"milk:derivative:medium"}},
+		{"en:hazelnut", []string{"hazelnuts:substring:medium", "tree_nuts:derivative:high"}},
+		{"en:hazelnuts", []string{"hazelnuts:exact:high", "tree_nuts:derivative:high"}},
+		{"en:walnut", []string{"tree_nuts:derivative:high", "walnuts:substring:medium"}},
+		{"en:cashew", []string{"cashews:substring:medium", "tree_nuts:derivative:high"}},
+		{"en:nut", []string{"nuts:substring:medium", "tree_nuts:derivative:high"}},
+		{"en:roasted-cashew-nuts", []string{"cashews:substring:medium", "nuts:substring:medium", "tree_nuts:derivative:medium"}},
+		{"en:tiger-nuts", []string{}},
+		{"en:peanut", []string{"peanuts:exact:high"}},
+		{"en:prawns", []string{"shrimp:derivative:high"}},
+		{"en:gluten-free-oat-flakes", []string{"oats:substring:medium"}},
+		{"en:lactose-free-milk", []string{"milk:substring:medium"}},
+		{"en:peanut-butter", []string{"peanuts:substring:medium"}},
+		{"en:coconut-milk", []string{"coconut:substring:medium"}},
+		{"en:buckwheat", []string{"buckwheat:exact:high"}},
+		{"en:wheatflour", []string{"wheat:substring:low"}},
+		{"en:egg-free-mayonnaise", []string{}},
+		{"en:dairy-free-cheese", []string{}},
+		{"en:bean-curd", []string{}},
+		{"en:milk-thistle", []string{}},
+		{"en:cheese-curd", []string{"milk:derivative:high"}},
+		{"fr:noisette", []string{}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, match := range matchIngredientTag(tt.tag) {
+			if match.Ingredient != tt.tag {
+				t.Errorf("%s: match credited to %s", tt.tag, match.Ingredient)
+			}
+			got = append(got, strings.Join([]string{match.Allergen, match.Rule, match.Confidence}, ":"))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestInferIngredientAllergensHierarchy(t *testing.T) {
+	saved := IngredientsTaxonomy
+	defer func() { IngredientsTaxonomy = saved }()
+	IngredientsTaxonomy = nil
+
+	product := OpenFoodFactsProduct{
+		IngredientsTags:      []string{"en:sugar", "en:skimmed-milk-powder"},
+		IngredientsHierarchy: []string{"en:sugar", "en:skimmed-milk-powder", "en:milk-powder", "en:milk"},
+	}
+	allergens, matches := inferIngredientAllergens(product)
+	if !reflect.DeepEqual(allergens.Values(), []string{"milk"}) {
+		t.Fatalf("got allergens %v, want [milk]", allergens.Values())
+	}
+	for _, source := range allergens.Provenance()["milk"] {
+		if source.Tag != "en:skimmed-milk-powder" {
+			t.Errorf("milk credited to %s (%s), want en:skimmed-milk-powder", source.Tag, source.Field)
+		}
+	}
+	if len(matches) != 1 || matches[0].Ingredient != "en:skimmed-milk-powder" {
+		t.Errorf("got matches %+v", matches)
+	}
+
+	// With the taxonomy the listed ingredient is found through its parents
+	IngredientsTaxonomy, _ = ParseTaxonomy(strings.NewReader("en: Milk\n\n< en:Milk\nen: Whey\nfr: Petit-lait\n"))
+	product = OpenFoodFactsProduct{
+		IngredientsTags:      []string{"fr:petit-lait"},
+		IngredientsHierarchy: []string{"fr:petit-lait", "en:milk"},
+	}
+	_, matches = inferIngredientAllergens(product)
+	want := IngredientAllergenMatch{Allergen: "milk", Ingredient: "fr:petit-lait", Parent: "en:milk", Rule: "hierarchy", Confidence: ConfidenceHigh}
+	if len(matches) != 1 || matches[0] != want {
+		t.Errorf("got matches %+v, want %+v", matches, want)
+	}
+}
+
+func TestInferIngredientAllergensLowConfidence(t *testing.T) {
+	product := OpenFoodFactsProduct{IngredientsTags: []string{"en:tomatoes", "en:whey"}}
+	allergens, matches := inferIngredientAllergens(product)
+	if !reflect.DeepEqual(allergens.Values(), []string{"milk"}) {
+		t.Errorf("got allergens %v, want [milk]", allergens.Values())
+	}
+	want := []IngredientAllergenMatch{
+		{Allergen: "milk", Ingredient: "en:whey", Rule: "derivative", Confidence: ConfidenceHigh},
+		{Allergen: "tomato", Ingredient: "en:tomatoes", Rule: "substring", Confidence: ConfidenceLow},
+	}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("got matches %+v, want %+v", matches, want)
+	}
+}
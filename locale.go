@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Locale is the language and country the text of a product is written for
+type Locale struct {
+	Lang         string
+	Country      string // OFF country tag, e.g. "en:switzerland", empty when the product is sold in several countries
+	DecimalComma bool   // Numbers are written "1.234,5" instead of "1,234.5"
+}
+
+// DecimalCommaLangs are the languages that write decimals with a comma
+var DecimalCommaLangs = map[string]bool{
+	"fr": true, "de": true, "es": true, "it": true, "pt": true, "nl": true, "pl": true, "ro": true, "ru": true, "uk": true,
+	"bg": true, "el": true, "tr": true, "cs": true, "sk": true, "sv": true, "da": true, "fi": true, "nb": true, "hu": true,
+	"hr": true, "sl": true, "lt": true, "lv": true, "et": true, "ca": true,
+}
+
+// CountryDecimalComma overrides the language for countries whose convention differs from other countries of
+// the language, e.g. German in Switzerland or Spanish in Mexico use a decimal point
+var CountryDecimalComma = map[string]bool{
+	"en:switzerland":    false,
+	"en:mexico":         false,
+	"en:united-states":  false,
+	"en:united-kingdom": false,
+	"en:ireland":        false,
+	"en:australia":      false,
+	"en:south-africa":   true,
+	"en:brazil":         true,
+	"en:argentina":      true,
+	"en:belgium":        true,
+}
+
+// newLocale returns the number conventions of a language, or of the country when it has its own
+func newLocale(lang string, country string) Locale {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	locale := Locale{Lang: lang, Country: country, DecimalComma: DecimalCommaLangs[lang]}
+	if decimalComma, ok := CountryDecimalComma[country]; ok {
+		locale.DecimalComma = decimalComma
+	}
+	return locale
+}
+
+// localeForProduct returns the locale of the product lang and, when it is sold in a single country, its country
+func localeForProduct(product OpenFoodFactsProduct) Locale {
+	country := ""
+	if len(product.CountriesTags) == 1 {
+		country = strings.ToLower(strings.TrimSpace(product.CountriesTags[0]))
+	}
+	return newLocale(product.Lang, country)
+}
+
+// parseLocaleNumber parses a number written with the conventions of the locale, e.g. "1.234,5" in German.
+// Comparison signs before the number, e.g. "<0.5", and a trailing unit, e.g. "12 g", are ignored.
+func parseLocaleNumber(text string, locale Locale) (float64, error) {
+	value, _, _, err := parseQualifiedNumber(text, locale)
+	return value, err
+}
+
+// parseQualifiedNumber parses a number like parseLocaleNumber and returns the qualifier of the comparison sign
+// before it, e.g. QualifierLessThan for "<0.5", and the unit after it, e.g. "mg" for "40 mg".
+// Words for traces, e.g. "traces", are zero with QualifierTrace.
+func parseQualifiedNumber(text string, locale Locale) (float64, string, string, error) {
+	if TraceWords[strings.ToLower(strings.TrimSpace(text))] {
+		return 0, QualifierTrace, "", nil
+	}
+
+	runes := []rune(strings.TrimSpace(text))
+	i := 0
+	for i < len(runes) && (strings.ContainsRune("<>≤≥~≈=", runes[i]) || unicode.IsSpace(runes[i])) {
+		i++
+	}
+	qualifier := modifierQualifier(string(runes[:i]))
+
+	start := i
+	if i < len(runes) && runes[i] == '-' {
+		i++
+	}
+	// A leading decimal separator, e.g. ".5"
+	if i+1 < len(runes) && (runes[i] == '.' || runes[i] == ',') && unicode.IsDigit(runes[i+1]) {
+		i++
+	}
+	digits := i
+	for i < len(runes) && (unicode.IsDigit(runes[i]) || isNumberSeparator(runes, i)) {
+		i++
+	}
+	number := string(runes[start:i])
+	if i == digits {
+		return 0, "", "", fmt.Errorf("no number in %q", text)
+	}
+
+	// A unit may follow the number, other text means the value is not a number
+	unit := strings.TrimSpace(string(runes[i:]))
+	if unit != "" {
+		first := []rune(unit)[0]
+		if !unicode.IsLetter(first) && first != '%' && first != '°' {
+			return 0, "", "", fmt.Errorf("invalid number %q", text)
+		}
+	}
+
+	value, err := strconv.ParseFloat(normalizeLocaleNumber(number, locale), 64)
+	return value, qualifier, unit, err
+}
+
+// isNumberSeparator reports whether runes[i] separates the digits of a number, e.g. "1,5", "1.000" or "1 000"
+func isNumberSeparator(runes []rune, i int) bool {
+	if i == 0 || i+1 >= len(runes) || !unicode.IsDigit(runes[i-1]) || !unicode.IsDigit(runes[i+1]) {
+		return false
+	}
+	switch runes[i] {
+	case '.', ',':
+		return true
+	case ' ', '\u00a0', '\u202f', '\'', '’':
+		// Spaces and apostrophes only group thousands
+		return isDigitGroup(runes, i+1)
+	default:
+		return false
+	}
+}
+
+// isDigitGroup reports whether exactly three digits start at runes[i]
+func isDigitGroup(runes []rune, i int) bool {
+	end := i
+	for end < len(runes) && unicode.IsDigit(runes[end]) {
+		end++
+	}
+	return end-i == 3
+}
+
+// normalizeLocaleNumber rewrites digits with separators as a number strconv can parse. With both a comma and a
+// period the last one is the decimal separator. A single separator followed by three digits, as in "1,000", is
+// ambiguous and read as the locale writes it, any other single separator is a decimal separator.
+func normalizeLocaleNumber(number string, locale Locale) string {
+	number = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\u00a0', '\u202f', '\'', '’':
+			return -1
+		}
+		return r
+	}, number)
+
+	lastComma := strings.LastIndex(number, ",")
+	lastPeriod := strings.LastIndex(number, ".")
+	decimal := ""
+	switch {
+	case lastComma >= 0 && lastPeriod >= 0:
+		decimal = ","
+		if lastPeriod > lastComma {
+			decimal = "."
+		}
+	case lastComma >= 0 || lastPeriod >= 0:
+		separator := ","
+		if lastPeriod >= 0 {
+			separator = "."
+		}
+		parts := strings.Split(number, separator)
+		switch {
+		case len(parts) > 2:
+			// Only thousands repeat, e.g. "1.000.000"
+		case len(parts[1]) != 3 || parts[0] == "0":
+			decimal = separator
+		case (separator == ",") == locale.DecimalComma:
+			decimal = separator
+		}
+	}
+
+	integer, fraction := number, ""
+	if decimal != "" {
+		index := strings.LastIndex(number, decimal)
+		integer, fraction = number[:index], number[index+1:]
+	}
+	integer = strings.NewReplacer(",", "", ".", "").Replace(integer)
+	if fraction == "" {
+		return integer
+	}
+	return integer + "." + fraction
+}
+
+// Qualifiers of a nutrient value, recorded in ServingSize.NutrientQualifiers unless the value is exact
+const (
+	QualifierExact         = "exact"
+	QualifierLessThan      = "less_than"
+	QualifierGreaterThan   = "greater_than"
+	QualifierApproximately = "approximately"
+	QualifierTrace         = "trace"
+)
+
+// TraceWords are the values OFF contributors enter for traces of a nutrient
+var TraceWords = map[string]bool{
+	"tr": true, "trace": true, "traces": true, "traza": true, "trazas": true, "spuren": true, "tracce": true, "sporen": true,
+}
+
+// modifierQualifier returns the qualifier of an OFF _modifier value, e.g. "<" or "~", or of a comparison sign.
+// Qualifier names are accepted too, as normalizeNutriments stores them for values such as "traces".
+func modifierQualifier(modifier string) string {
+	modifier = strings.ToLower(strings.TrimSpace(modifier))
+	switch {
+	case modifier == "" || modifier == "=":
+		return QualifierExact
+	case strings.HasPrefix(modifier, "<") || strings.HasPrefix(modifier, "≤") || modifier == QualifierLessThan:
+		return QualifierLessThan
+	case strings.HasPrefix(modifier, ">") || strings.HasPrefix(modifier, "≥") || modifier == QualifierGreaterThan:
+		return QualifierGreaterThan
+	case modifier == "~" || modifier == "≈" || modifier == QualifierApproximately:
+		return QualifierApproximately
+	case TraceWords[modifier] || modifier == QualifierTrace:
+		return QualifierTrace
+	default:
+		return QualifierExact
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseLocaleNumber(t *testing.T) {
+	tests := []struct {
+		input  string
+		lang   string
+		value  float64
+		failed bool
+	}{
+		{"1,5", "en", 1.5, false},
+		{"1.5", "fr", 1.5, false},
+		{"1,000", "en", 1000, false},
+		{"1,000", "fr", 1, false},
+		{"1.000", "de", 1000, false},
+		{"1.000", "en", 1, false},
+		{"0,500", "en", 0.5, false},
+		{"1.234,5", "en", 1234.5, false},
+		{"1,234.5", "de", 1234.5, false},
+		{"1.000.000", "de", 1000000, false},
+		{"1 000", "fr", 1000, false},
+		{"12 g", "en", 12, false},
+		{"12,5g", "fr", 12.5, false},
+		{"<0.5", "en", 0.5, false},
+		{"~ 2", "en", 2, false},
+		{".5", "en", 0.5, false},
+		{"-2", "en", -2, false},
+		{"", "en", 0, true},
+		{"n/a", "en", 0, true},
+		{"0.5-1", "en", 0, true},
+	}
+
+	for _, test := range tests {
+		value, err := parseLocaleNumber(test.input, newLocale(test.lang, ""))
+		if (err != nil) != test.failed || value != test.value {
+			t.Errorf("%q in %s: got %g, %v, want %g", test.input, test.lang, value, err, test.value)
+		}
+	}
+}
+
+func TestParseQualifiedNumber(t *testing.T) {
+	tests := []struct {
+		input     string
+		value     float64
+		qualifier string
+	}{
+		{"0.5", 0.5, QualifierExact},
+		{"<0.5", 0.5, QualifierLessThan},
+		{"< 0,5 g", 0.5, QualifierLessThan},
+		{"≤1", 1, QualifierLessThan},
+		{">10", 10, QualifierGreaterThan},
+		{"~2", 2, QualifierApproximately},
+		{"traces", 0, QualifierTrace},
+		{"Tr", 0, QualifierTrace},
+	}
+
+	for _, test := range tests {
+		value, qualifier, _, err := parseQualifiedNumber(test.input, newLocale("en", ""))
+		if err != nil || value != test.value || qualifier != test.qualifier {
+			t.Errorf("%q: got %g %s, %v, want %g %s", test.input, value, qualifier, err, test.value, test.qualifier)
+		}
+	}
+}
+
+func TestNewLocale(t *testing.T) {
+	if locale := newLocale("de", ""); !locale.DecimalComma {
+		t.Errorf("de: got a decimal point")
+	}
+	if locale := newLocale("de", "en:switzerland"); locale.DecimalComma {
+		t.Errorf("de in Switzerland: got a decimal comma")
+	}
+	if locale := localeForProduct(OpenFoodFactsProduct{Lang: "en", CountriesTags: []string{"en:south-africa"}}); !locale.DecimalComma {
+		t.Errorf("en in South Africa: got a decimal point")
+	}
+	if locale := localeForProduct(OpenFoodFactsProduct{Lang: "en", CountriesTags: []string{"en:south-africa", "en:united-kingdom"}}); locale.DecimalComma {
+		t.Errorf("en in several countries: got a decimal comma")
+	}
+}
+
+func TestNormalizeNutriments(t *testing.T) {
+	nutriments, issues := normalizeNutriments(map[string]interface{}{
+		"fat_100g":                  "1,5",
+		"sugars_100g":               "<0,5",
+		"fiber_100g":                "traces",
+		"salt_100g":                 "1.234,5 mg",
+		"sodium_serving":            "40 mg",
+		"vitamin-d_100g":            "200 UI",
+		"saturated-fat_100g":        "3 %",
+		"vitamin-c_100g":            "15 %",
+		"energy-kcal_100g":          "1046 kJ",
+		"energy_prepared_100g":      "100 kcal",
+		"alcohol_100g":              "5 % vol",
+		"carbohydrates_100g":        "12 cups",
+		"proteins_serving":          "3 kcal",
+		"proteins_100g":             12.0,
+		"proteins_unit":             "g",
+		"sugars_modifier":           "<",
+		"vitamin-c_value":           "40 mg",
+		"energy-kcal_prepared_100g": 100.0,
+	}, newLocale("fr", ""))
+
+	expected := map[string]interface{}{
+		"fat_100g":             1.5,
+		"sugars_100g":          0.5,
+		"fiber_100g":           0.0,
+		"fiber_modifier":       QualifierTrace,
+		"salt_100g":            1.2345,
+		"sodium_serving":       0.04,
+		"vitamin-d_100g":       5e-6,
+		"energy-kcal_100g":     250.0,
+		"energy_prepared_100g": 418.4,
+		"alcohol_100g":         5.0,
+		"proteins_100g":        12.0,
+		"proteins_unit":        "g",
+		"sugars_modifier":      "<",
+		"vitamin-c_value":      40.0,
+	}
+	for key, value := range expected {
+		got, ok := nutriments[key].(float64)
+		if want, isNumber := value.(float64); isNumber && ok {
+			if math.Abs(got-want) > 1e-9*math.Max(1, want) {
+				t.Errorf("%s: got %v, want %v", key, got, want)
+			}
+		} else if nutriments[key] != value {
+			t.Errorf("%s: got %v, want %v", key, nutriments[key], value)
+		}
+	}
+
+	// Values in units that cannot be converted are left out and reported
+	codes := map[string]string{}
+	for _, issue := range issues {
+		codes[issue.Nutrient] = issue.Code
+	}
+	for key, code := range map[string]string{"carbohydrates_100g": IssueUnknownUnit, "proteins_serving": IssueUnconvertibleUnit, "saturated-fat_100g": IssueUnconvertibleUnit, "vitamin-c_100g": IssueUnconvertibleUnit} {
+		if _, ok := nutriments[key]; ok {
+			t.Errorf("%s: got %v, want it left out", key, nutriments[key])
+		}
+		if codes[key] != code {
+			t.Errorf("%s: got issue %q, want %q", key, codes[key], code)
+		}
+	}
+	if len(issues) != 4 {
+		t.Errorf("got issues %+v, want 4", issues)
+	}
+}
@@ -4,12 +4,11 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"regexp"
-	"strconv"
 	"strings"
 	"unicode"
 )
@@ -40,7 +39,23 @@ type OpenFoodFactsProduct struct {
 	Nutriments      map[string]interface{} `json:"nutriments"`
 	Allergens       string                 `json:"allergens"`
 	AllergensTags   []string               `json:"allergens_tags"`
+	Traces          string                 `json:"traces"`
+	TracesTags      []string               `json:"traces_tags"`
 	IngredientsTags []string               `json:"ingredients_tags"`
+
+	IngredientsHierarchy []string `json:"ingredients_hierarchy"`
+
+	CategoriesTags           []string `json:"categories_tags"`
+	CountriesTags            []string `json:"countries_tags"`
+	NutritionDataPer         string   `json:"nutrition_data_per"`
+	NutritionDataPreparedPer string   `json:"nutrition_data_prepared_per"`
+
+	ServingQuantity     interface{} `json:"serving_quantity"` // Serving weight OFF computed from serving_size
+	ServingQuantityUnit string      `json:"serving_quantity_unit"`
+
+	Quantity            string      `json:"quantity"`
+	ProductQuantity     interface{} `json:"product_quantity"` // A number or a numeric string in product_quantity_unit
+	ProductQuantityUnit string      `json:"product_quantity_unit"`
 }
 
 type FoodItem struct {
@@ -50,50 +65,45 @@ type FoodItem struct {
 	Barcode             string            `json:"barcode"`
 	ServingSizes        []ServingSize     `json:"serving_sizes"`
 	Allergens           []string          `json:"allergens"`
+	TraceAllergens      []string          `json:"trace_allergens"`
+	UnmappedAllergens   []string          `json:"unmapped_allergens,omitempty"`
+	UnmappedTraces      []string          `json:"unmapped_traces,omitempty"`
 	IngredientAllergens []string          `json:"ingredient_allergens"`
 	Translations        map[string]string `json:"translations"`
+
+	IngredientAllergenMatches []IngredientAllergenMatch   `json:"ingredient_allergen_matches,omitempty"`
+	AllergenProvenance        map[string][]AllergenSource `json:"allergen_provenance,omitempty"`
+
+	// QualityFlags are the codes of every data-quality issue, the details are in the quality report
+	QualityFlags []string       `json:"quality_flags,omitempty"`
+	Issues       []QualityIssue `json:"-"`
 }
 
 type ServingSize struct {
-	MeasurementUnit    string  `json:"measurement_unit"`
-	Type               int     `json:"type"`
-	Quantity           float64 `json:"quantity"`
-	WeightInGrams      float64 `json:"weight_in_grams"`
-	Calories           float64 `json:"calories,omitempty"`
-	Protein            float64 `json:"protein,omitempty"`
-	Fat                float64 `json:"fat,omitempty"`
-	Carbs              float64 `json:"carbs,omitempty"`
-	Fiber              float64 `json:"fiber,omitempty"`
-	Sugar              float64 `json:"sugar,omitempty"`
-	Sodium             float64 `json:"sodium,omitempty"`
-	Cholesterol        float64 `json:"cholesterol,omitempty"`
-	Calcium            float64 `json:"calcium,omitempty"`
-	Iron               float64 `json:"iron,omitempty"`
-	Potassium          float64 `json:"potassium,omitempty"`
-	Magnesium          float64 `json:"magnesium,omitempty"`
-	Zinc               float64 `json:"zinc,omitempty"`
-	VitaminAIU         float64 `json:"vitamin_a_iu,omitempty"`
-	VitaminC           float64 `json:"vitamin_c,omitempty"`
-	VitaminD           float64 `json:"vitamin_d,omitempty"`
-	VitaminDIU         float64 `json:"vitamin_d_iu,omitempty"`
-	VitaminE           float64 `json:"vitamin_e,omitempty"`
-	VitaminK           float64 `json:"vitamin_k,omitempty"`
-	Thiamin            float64 `json:"thiamin,omitempty"`
-	Riboflavin         float64 `json:"riboflavin,omitempty"`
-	Niacin             float64 `json:"niacin,omitempty"`
-	VitaminB6          float64 `json:"vitamin_b6,omitempty"`
-	Folate             float64 `json:"folate,omitempty"`
-	VitaminB12         float64 `json:"vitamin_b12,omitempty"`
-	Phosphorus         float64 `json:"phosphorus,omitempty"`
-	Copper             float64 `json:"copper,omitempty"`
-	Manganese          float64 `json:"manganese,omitempty"`
-	Selenium           float64 `json:"selenium,omitempty"`
-	Water              float64 `json:"water,omitempty"`
-	Ash                float64 `json:"ash,omitempty"`
-	SaturatedFat       float64 `json:"saturated_fat,omitempty"`
-	MonounsaturatedFat float64 `json:"monounsaturated_fat,omitempty"`
-	PolyunsaturatedFat float64 `json:"polyunsaturated_fat,omitempty"`
-	TransFat           float64 `json:"trans_fat,omitempty"`
+	MeasurementUnit string  `json:"measurement_unit"`
+	UnitID          string  `json:"unit_id,omitempty"`       // Canonical unit, the same in every language, e.g. "tbsp" for "EL"
+	UnitSingular    string  `json:"unit_singular,omitempty"` // Display forms of the unit ID, e.g. "slice" and "slices"
+	UnitPlural      string  `json:"unit_plural,omitempty"`
+	Type            int     `json:"type"`
+	Quantity        float64 `json:"quantity"`
+	WeightInGrams   float64 `json:"weight_in_grams"`
+	WeightSource    string  `json:"weight_source,omitempty"` // "declared" or "estimated" from a volume or household measure
+	EnergySource    string  `json:"energy_source,omitempty"`
+
+	// Prepared servings hold the nutrition of the product once prepared, e.g. a soup powder with water
+	Prepared bool `json:"prepared,omitempty"`
+
+	// DerivedNutrients lists the fields OFF has no value for, which were scaled from the per-100g values
+	// or calculated from another nutrient, such as sodium from salt
+	DerivedNutrients []string `json:"derived_nutrients,omitempty"`
+
+	// NutrientQualifiers holds the qualifier of the nutrients whose value is not exact, e.g. "less_than" for
+	// sugars reported as "<0.5", so the value can be shown as "<0.5 g"
+	NutrientQualifiers map[string]string `json:"nutrient_qualifiers,omitempty"`
+
+	// Nutrients holds the values of the NutrientRegistry fields, see MarshalJSON. A missing key means
+	// OFF has no value for the nutrient, a zero value means OFF reported zero.
+	Nutrients map[string]float64 `json:"-"`
 }
 
 // AllergenMap maps various allergen strings to standardized values
@@ -115,6 +125,9 @@ var AllergenMap = map[string]string{
 	"SOYBEAN":              "soy",
 	"SOYBEANS":             "soy",
 	"SESAME":               "sesame",
+	"SESAME SEEDS":         "sesame",
+	"SESAME_SEEDS":         "sesame",
+	"CRUSTACEANS":          "crustacean_shellfish",
 
 	// Specific tree nuts
 	"ALMONDS":        "almonds",
@@ -169,8 +182,10 @@ var AllergenMap = map[string]string{
 	"CELERY":          "celery",
 	"MUSTARD":         "mustard",
 	"SULFITES":        "sulfites",
+	"SULPHITES":       "sulfites",
 	"LUPIN":           "lupin",
 	"MOLLUSKS":        "mollusks",
+	"MOLLUSCS":        "mollusks",
 	"CORN":            "corn",
 	"GELATIN":         "gelatin",
 	"SEEDS":           "seeds",
@@ -202,19 +217,93 @@ var AllergenMap = map[string]string{
 	"YELLOW_5":        "yellow_5",
 	"RED 40":          "red_40",
 	"RED_40":          "red_40",
+
+	// Names used by the Open Food Facts allergens taxonomy
+	"SULPHUR DIOXIDE AND SULPHITES": "sulfites",
+	"SULPHUR_DIOXIDE_AND_SULPHITES": "sulfites",
+}
+
+// AllergenSynonyms maps multilingual taxonomy names ("fr:lait", "de:milch") to AllergenMap values.
+// It is populated from the OFF taxonomies in the input folder when they are present.
+var AllergenSynonyms = map[string]string{}
+
+// Config holds the command line options
+type Config struct {
+	AllergenProvenance bool
+	InvalidProducts    string
+	LegacyOmitEmpty    bool
+
+	// MinServingConfidence is the lowest parser confidence for which serving_size is emitted
+	MinServingConfidence float64
+
+	// ServingRangeValue is the value used for ranges such as "2-3 cookies": low, mid or high
+	ServingRangeValue string
+}
+
+var config = Config{
+	InvalidProducts:      InvalidProductsKeep,
+	MinServingConfidence: MIN_SERVING_CONFIDENCE,
+	ServingRangeValue:    ServingRangeMid,
 }
 
 const INPUT_FILE = "input/openfoodfacts-products.jsonl.gz"
+const ALLERGENS_TAXONOMY_FILE = "input/allergens.txt"
+const INGREDIENTS_TAXONOMY_FILE = "input/ingredients.txt"
 const OUTPUT_DIR = "output"
 const CHUNK_SIZE = 50000
+const QUALITY_REPORT_FILE = OUTPUT_DIR + "/quality_report.jsonl"
+const QUARANTINE_FILE = OUTPUT_DIR + "/quarantine.jsonl"
 
 func main() {
+	flag.BoolVar(&config.AllergenProvenance, "allergen-provenance", false, "Record the source field and tag of every allergen in allergen_provenance")
+	flag.BoolVar(&config.LegacyOmitEmpty, "legacy-omitempty", false, "Omit nutrients with a value of zero, like the output for old app versions")
+	flag.StringVar(&config.InvalidProducts, "invalid-products", InvalidProductsKeep, "What to do with products that fail validation: keep, drop or quarantine")
+	flag.Float64Var(&config.MinServingConfidence, "min-serving-confidence", MIN_SERVING_CONFIDENCE, "Lowest serving size parser confidence, between 0 and 1, for which the serving size is emitted")
+	flag.StringVar(&config.ServingRangeValue, "serving-range", ServingRangeMid, "Value used for serving size ranges such as \"2-3 cookies\": low, mid or high")
+	nutrientsConfig := flag.String("nutrients", "", "JSON file with additional or overriding nutrient definitions")
+	flag.Parse()
+
+	switch config.InvalidProducts {
+	case InvalidProductsKeep, InvalidProductsDrop, InvalidProductsQuarantine:
+	default:
+		log.Fatalf("Invalid value for --invalid-products: %s", config.InvalidProducts)
+	}
+
+	switch config.ServingRangeValue {
+	case ServingRangeLow, ServingRangeMid, ServingRangeHigh:
+	default:
+		log.Fatalf("Invalid value for --serving-range: %s", config.ServingRangeValue)
+	}
+
+	err := loadNutrientRegistry(*nutrientsConfig)
+	if err != nil {
+		log.Fatalf("Failed to load nutrient definitions: %v", err)
+	}
+
 	// Create output directory if it doesn't exist
-	err := os.MkdirAll(OUTPUT_DIR, 0755)
+	err = os.MkdirAll(OUTPUT_DIR, 0755)
 	if err != nil {
 		log.Fatalf("Failed to create output directory: %v", err)
 	}
 
+	loadAllergenTaxonomies()
+
+	qualityReport, err := NewQualityReport(QUALITY_REPORT_FILE)
+	if err != nil {
+		log.Fatalf("Failed to create quality report: %v", err)
+	}
+	defer qualityReport.Close()
+
+	var quarantineFile *os.File
+	if config.InvalidProducts == InvalidProductsQuarantine {
+		quarantineFile, err = os.Create(QUARANTINE_FILE)
+		if err != nil {
+			log.Fatalf("Failed to create quarantine file: %v", err)
+		}
+		defer quarantineFile.Close()
+	}
+	droppedCount := 0
+
 	inputFile, err := os.Open(INPUT_FILE)
 	if err != nil {
 		log.Fatalf("Failed to open input file: %v", err)
@@ -232,11 +321,15 @@ func main() {
 	lineCount := 0
 	processedCount := 0
 	chunkCount := 0
+	chunkStart := -1
 	var currentFile *os.File
 	defer currentFile.Close()
 
 	for {
-		if processedCount%CHUNK_SIZE == 0 {
+		// Skipped and dropped products don't advance processedCount, so only start each chunk once
+		if processedCount%CHUNK_SIZE == 0 && processedCount != chunkStart {
+			chunkStart = processedCount
+
 			// Close previous file if it exists
 			if currentFile != nil {
 				currentFile.Close()
@@ -269,6 +362,11 @@ func main() {
 			continue
 		}
 
+		err = qualityReport.Write(processedProduct)
+		if err != nil {
+			log.Printf("Error writing to quality report: %v", err)
+		}
+
 		// Create a custom encoder that doesn't escape HTML
 		buffer := &bytes.Buffer{}
 		encoder := json.NewEncoder(buffer)
@@ -280,6 +378,17 @@ func main() {
 			continue
 		}
 
+		if config.InvalidProducts != InvalidProductsKeep && processedProduct.HasErrors() {
+			droppedCount++
+			if quarantineFile != nil {
+				_, err = quarantineFile.WriteString(buffer.String())
+				if err != nil {
+					log.Printf("Error writing to quarantine file: %v", err)
+				}
+			}
+			continue
+		}
+
 		_, err = currentFile.WriteString(buffer.String())
 		if err != nil {
 			log.Printf("Error writing to output file: %v", err)
@@ -292,8 +401,31 @@ func main() {
 		}
 	}
 
-	log.Printf("Completed processing. Total lines: %d, Products processed: %d, Chunks created: %d",
-		lineCount, processedCount, chunkCount)
+	log.Printf("Completed processing. Total lines: %d, Products processed: %d, Chunks created: %d, Products dropped by validation: %d",
+		lineCount, processedCount, chunkCount, droppedCount)
+}
+
+// loadAllergenTaxonomies populates AllergenSynonyms and IngredientsTaxonomy from the optional OFF taxonomy files
+func loadAllergenTaxonomies() {
+	ingredientsTaxonomy, err := LoadTaxonomy(INGREDIENTS_TAXONOMY_FILE)
+	if err != nil {
+		log.Printf("Ingredients taxonomy not loaded, ingredient parents will only come from ingredients_hierarchy and ingredient-only allergen names such as fr:petit-lait will not be recognized: %v", err)
+		ingredientsTaxonomy = nil
+	}
+	IngredientsTaxonomy = ingredientsTaxonomy
+
+	allergensTaxonomy, err := LoadTaxonomy(ALLERGENS_TAXONOMY_FILE)
+	if err != nil {
+		log.Printf("Allergen taxonomy not loaded, only English allergen tags will be recognized: %v", err)
+		allergensTaxonomy = nil
+	}
+
+	if allergensTaxonomy == nil && ingredientsTaxonomy == nil {
+		return
+	}
+
+	AllergenSynonyms = BuildAllergenSynonyms(allergensTaxonomy, ingredientsTaxonomy)
+	log.Printf("Loaded %d allergen synonyms", len(AllergenSynonyms))
 }
 
 func ProcessProduct(product OpenFoodFactsProduct) (*FoodItem, error) {
@@ -381,144 +513,131 @@ func ProcessProduct(product OpenFoodFactsProduct) (*FoodItem, error) {
 		return nil, fmt.Errorf("product name and barcode are empty")
 	}
 
-	allergens := product.AllergensTags
-	if len(allergens) == 0 && product.Allergens != "" {
-		allergens = strings.Split(product.Allergens, ",")
-	}
-	for i, allergen := range allergens {
-		allergens[i] = normalizeAllergen(allergen)
-	}
-
-	ingredientAllergens := []string{}
-	for _, ingredientTag := range product.IngredientsTags {
-		ingredientAllergen := extractIngredientAllergen(ingredientTag)
-		if ingredientAllergen != "" {
-			ingredientAllergens = append(ingredientAllergens, ingredientAllergen)
-		}
-	}
+	allergens, unmappedAllergens := extractDeclaredAllergens(product)
+	traceAllergens, unmappedTraces := extractTraceAllergens(product)
+	ingredientAllergens, ingredientAllergenMatches := inferIngredientAllergens(product)
 
 	servingSizes := []ServingSize{}
 
-	nutriments := product.Nutriments
+	// Numeric strings, e.g. "1,5" on a French product, are parsed once in the product locale
+	locale := localeForProduct(product)
+	nutriments, nutrimentIssues := normalizeNutriments(product.Nutriments, locale)
 
-	// Always include per-100g serving size
-	per100gServing := ServingSize{
-		MeasurementUnit: "g",
-		Type:            1,
-		Quantity:        100,
-		WeightInGrams:   100,
-	}
+	food, _ := foodDensityForProduct(product)
 
-	// For per 100g serving size
-	mapNutrient(&per100gServing.Calories, nutriments, "", "energy-kcal_100g")
-	mapNutrient(&per100gServing.Protein, nutriments, "", "proteins_100g")
-	mapNutrient(&per100gServing.Fat, nutriments, "", "fat_100g")
-	mapNutrient(&per100gServing.Carbs, nutriments, "", "carbohydrates_100g")
-	mapNutrient(&per100gServing.Fiber, nutriments, "", "fiber_100g")
-	mapNutrient(&per100gServing.Sugar, nutriments, "", "sugars_100g")
-	mapNutrient(&per100gServing.Sodium, nutriments, "g_to_mg", "sodium_100g")
-	mapNutrient(&per100gServing.Cholesterol, nutriments, "g_to_mg", "cholesterol_100g")
-	mapNutrient(&per100gServing.Calcium, nutriments, "g_to_mg", "calcium_100g")
-	mapNutrient(&per100gServing.Iron, nutriments, "g_to_mg", "iron_100g")
-	mapNutrient(&per100gServing.Potassium, nutriments, "g_to_mg", "potassium_100g")
-	mapNutrient(&per100gServing.Magnesium, nutriments, "g_to_mg", "magnesium_100g")
-	mapNutrient(&per100gServing.Zinc, nutriments, "g_to_mg", "zinc_100g")
-	mapNutrient(&per100gServing.VitaminAIU, nutriments, "", "vitamin-a_iu_100g")
-	mapNutrient(&per100gServing.VitaminC, nutriments, "g_to_mg", "vitamin-c_100g")
-	mapNutrient(&per100gServing.VitaminD, nutriments, "g_to_ug", "vitamin-d_100g")
-	mapNutrient(&per100gServing.VitaminDIU, nutriments, "", "vitamin-d_iu_100g")
-	mapNutrient(&per100gServing.VitaminE, nutriments, "g_to_mg", "vitamin-e_100g")
-	mapNutrient(&per100gServing.VitaminK, nutriments, "g_to_ug", "vitamin-k_100g")
-	mapNutrient(&per100gServing.Thiamin, nutriments, "g_to_mg", "thiamin_100g")
-	mapNutrient(&per100gServing.Riboflavin, nutriments, "g_to_mg", "riboflavin_100g")
-	mapNutrient(&per100gServing.Niacin, nutriments, "g_to_mg", "niacin_100g")
-	mapNutrient(&per100gServing.VitaminB6, nutriments, "g_to_mg", "vitamin-b6_100g")
-	mapNutrient(&per100gServing.Folate, nutriments, "g_to_ug", "folate_100g")
-	mapNutrient(&per100gServing.VitaminB12, nutriments, "g_to_ug", "vitamin-b12_100g")
-	mapNutrient(&per100gServing.Phosphorus, nutriments, "g_to_mg", "phosphorus_100g")
-	mapNutrient(&per100gServing.Copper, nutriments, "g_to_mg", "copper_100g")
-	mapNutrient(&per100gServing.Manganese, nutriments, "g_to_mg", "manganese_100g")
-	mapNutrient(&per100gServing.Selenium, nutriments, "g_to_ug", "selenium_100g")
-	mapNutrient(&per100gServing.Water, nutriments, "", "water_100g")
-	mapNutrient(&per100gServing.Ash, nutriments, "", "ash_100g")
-	mapNutrient(&per100gServing.SaturatedFat, nutriments, "", "saturated-fat_100g")
-	mapNutrient(&per100gServing.MonounsaturatedFat, nutriments, "", "monounsaturated-fat_100g")
-	mapNutrient(&per100gServing.PolyunsaturatedFat, nutriments, "", "polyunsaturated-fat_100g")
-	mapNutrient(&per100gServing.TransFat, nutriments, "", "trans-fat_100g")
-
-	if per100gServing.Calories == 0 {
-		per100gServing.Calories = per100gServing.Protein*4 + per100gServing.Fat*9 + per100gServing.Carbs*4
+	// Always include the per-100g or per-100ml serving size the OFF values refer to
+	referenceServing := newReferenceServing(product.NutritionDataPer, food)
+
+	issues := append(nutrimentIssues, extractNutrients(&referenceServing, nutriments, NutrimentsAsSold, "_100g")...)
+	completeNutrients(&referenceServing)
+
+	// Prepared values are only included when OFF has any of them
+	preparedReferenceServing := newReferenceServing(product.NutritionDataPreparedPer, food)
+	preparedReferenceServing.Prepared = true
+	preparedIssues := extractNutrients(&preparedReferenceServing, nutriments, NutrimentsPrepared, "_100g")
+	hasPrepared := len(preparedReferenceServing.Nutrients) > 0
+	if hasPrepared {
+		issues = append(issues, preparedIssues...)
+		completeNutrients(&preparedReferenceServing)
 	}
 
+	preparedServingSizes := []ServingSize{}
+
 	// If serving size information is available, include it as an additional serving size
-	if product.ServingSize != "" {
-		quantity, measurementUnit, weightInGrams, servingType := parseServingSize(product.ServingSize)
+	servingQuantity, _ := parseOFFComputedNumber(product.ServingQuantity)
+	if product.ServingSize != "" || servingQuantity > 0 {
+		parsed := parseServingSize(product.ServingSize, locale, food)
+		if servingQuantity > 0 {
+			issues = append(issues, applyServingQuantity(&parsed, servingQuantity, product.ServingQuantityUnit, food)...)
+		}
 
-		// If weightInGrams is zero, try to calculate it based on the measurementUnit
-		if weightInGrams == 0 && quantity > 0 {
-			weightInGrams = convertToGrams(quantity, measurementUnit)
+		// Guesses such as "about 3 pieces (approx" are reported instead of emitted
+		lowConfidence := parsed.Confidence < config.MinServingConfidence
+		if lowConfidence {
+			issues = append(issues, QualityIssue{Code: IssueUnparsedServingSize, Severity: SeverityWarning, Detail: fmt.Sprintf("%q matched rule %s with confidence %g", product.ServingSize, parsed.Rule, parsed.Confidence)})
 		}
 
-		lowerMeasurementUnit := strings.ToLower(measurementUnit)
-		skip := lowerMeasurementUnit == "" || lowerMeasurementUnit == "100g" || lowerMeasurementUnit == "100 g" || lowerMeasurementUnit == "100grams" || lowerMeasurementUnit == "100 grams"
-		if !skip && quantity > 0 && weightInGrams != 100 {
-			if len(measurementUnit) > 1 && isAsciiOnly(measurementUnit) {
+		// Compound strings such as "1 bar (45 g) or 2 bars (90 g)" add a serving size for every measure
+		measures := append([]ServingMeasure{parsed.Measure()}, parsed.Alternatives...)
+		for _, measure := range measures {
+			quantity, measurementUnit, weightInGrams, servingType, weightSource := measure.Quantity, measure.Unit, measure.WeightInGrams, measure.Type, measure.WeightSource
+
+			lowerMeasurementUnit := strings.ToLower(measurementUnit)
+			skip := lowConfidence || lowerMeasurementUnit == "" || lowerMeasurementUnit == "100g" || lowerMeasurementUnit == "100 g" || lowerMeasurementUnit == "100grams" || lowerMeasurementUnit == "100 grams"
+			if skip || quantity <= 0 || weightInGrams == 100 || weightInGrams == referenceServing.WeightInGrams {
+				continue
+			}
+
+			// Known units are shown the same way whatever the spelling, e.g. "slices" for "SLICES" or "tranches".
+			// "Serving" labels plain weights and keeps its spelling.
+			if displayName, ok := unitDisplayName(measure.UnitID, quantity); ok && measure.UnitID != UnitServing {
+				measurementUnit = displayName
+			} else if len(measurementUnit) > 1 && isAsciiOnly(measurementUnit) {
 				measurementUnit = toTitle(measurementUnit)
 			}
 
+			// OFF per-serving values refer to the declared serving, other weights are only scaled from per-100g
+			declaredWeight := weightInGrams == parsed.WeightInGrams
+
 			ss := ServingSize{
 				MeasurementUnit: measurementUnit,
 				Type:            servingType,
 				Quantity:        quantity,
 				WeightInGrams:   weightInGrams,
+				WeightSource:    weightSource,
+				Nutrients:       map[string]float64{},
 			}
+			ss.setUnitID(measure.UnitID)
 
-			mapNutrient(&ss.Calories, nutriments, "", "energy-kcal_serving")
-			mapNutrient(&ss.Protein, nutriments, "", "proteins_serving")
-			mapNutrient(&ss.Fat, nutriments, "", "fat_serving")
-			mapNutrient(&ss.Carbs, nutriments, "", "carbohydrates_serving")
-			mapNutrient(&ss.Fiber, nutriments, "", "fiber_serving")
-			mapNutrient(&ss.Sugar, nutriments, "", "sugars_serving")
-			mapNutrient(&ss.Sodium, nutriments, "g_to_mg", "sodium_serving")
-			mapNutrient(&ss.Cholesterol, nutriments, "g_to_mg", "cholesterol_serving")
-			mapNutrient(&ss.Calcium, nutriments, "g_to_mg", "calcium_serving")
-			mapNutrient(&ss.Iron, nutriments, "g_to_mg", "iron_serving")
-			mapNutrient(&ss.Potassium, nutriments, "g_to_mg", "potassium_serving")
-			mapNutrient(&ss.Magnesium, nutriments, "g_to_mg", "magnesium_serving")
-			mapNutrient(&ss.Zinc, nutriments, "g_to_mg", "zinc_serving")
-			mapNutrient(&ss.VitaminAIU, nutriments, "", "vitamin-a_iu_serving")
-			mapNutrient(&ss.VitaminC, nutriments, "g_to_mg", "vitamin-c_serving")
-			mapNutrient(&ss.VitaminD, nutriments, "g_to_ug", "vitamin-d_serving")
-			mapNutrient(&ss.VitaminDIU, nutriments, "", "vitamin-d_iu_serving")
-			mapNutrient(&ss.VitaminE, nutriments, "g_to_mg", "vitamin-e_serving")
-			mapNutrient(&ss.VitaminK, nutriments, "g_to_ug", "vitamin-k_serving")
-			mapNutrient(&ss.Thiamin, nutriments, "g_to_mg", "thiamin_serving")
-			mapNutrient(&ss.Riboflavin, nutriments, "g_to_mg", "riboflavin_serving")
-			mapNutrient(&ss.Niacin, nutriments, "g_to_mg", "niacin_serving")
-			mapNutrient(&ss.VitaminB6, nutriments, "g_to_mg", "vitamin-b6_serving")
-			mapNutrient(&ss.Folate, nutriments, "g_to_ug", "folate_serving")
-			mapNutrient(&ss.VitaminB12, nutriments, "g_to_ug", "vitamin-b12_serving")
-			mapNutrient(&ss.Phosphorus, nutriments, "g_to_mg", "phosphorus_serving")
-			mapNutrient(&ss.Copper, nutriments, "g_to_mg", "copper_serving")
-			mapNutrient(&ss.Manganese, nutriments, "g_to_mg", "manganese_serving")
-			mapNutrient(&ss.Selenium, nutriments, "g_to_ug", "selenium_serving")
-			mapNutrient(&ss.Water, nutriments, "", "water_serving")
-			mapNutrient(&ss.Ash, nutriments, "", "ash_serving")
-			mapNutrient(&ss.SaturatedFat, nutriments, "", "saturated-fat_serving")
-			mapNutrient(&ss.MonounsaturatedFat, nutriments, "", "monounsaturated-fat_serving")
-			mapNutrient(&ss.PolyunsaturatedFat, nutriments, "", "polyunsaturated-fat_serving")
-			mapNutrient(&ss.TransFat, nutriments, "", "trans-fat_serving")
-
-			if ss.Calories == 0 {
-				ss.Calories = ss.Protein*4 + ss.Fat*9 + ss.Carbs*4
+			if declaredWeight {
+				issues = append(issues, extractNutrients(&ss, nutriments, NutrimentsAsSold, "_serving")...)
 			}
+			issues = append(issues, deriveServingNutrients(&ss, referenceServing)...)
+			completeNutrients(&ss)
 
 			servingSizes = append(servingSizes, ss)
+
+			if !declaredWeight {
+				continue
+			}
+
+			// The serving weight is usually the product as sold, so prepared values are not scaled from per-100g
+			preparedServing := ServingSize{
+				MeasurementUnit: measurementUnit,
+				Type:            servingType,
+				Quantity:        quantity,
+				WeightInGrams:   weightInGrams,
+				WeightSource:    weightSource,
+				Prepared:        true,
+			}
+			preparedServing.setUnitID(measure.UnitID)
+			preparedIssues := extractNutrients(&preparedServing, nutriments, NutrimentsPrepared, "_serving")
+			if len(preparedServing.Nutrients) > 0 {
+				issues = append(issues, preparedIssues...)
+				completeNutrients(&preparedServing)
+				preparedServingSizes = append(preparedServingSizes, preparedServing)
+			}
 		}
+	}
+
+	servingSizes = append(servingSizes, referenceServing)
 
+	// The whole package, and a single unit of a multipack, as packaged
+	if pkg, ok := parsePackageQuantity(product, food); ok {
+		packageServingSizes, packageIssues := packageServingSizes(pkg, referenceServing, servingSizes)
+		servingSizes = append(servingSizes, packageServingSizes...)
+		issues = append(issues, packageIssues...)
 	}
 
-	servingSizes = append(servingSizes, per100gServing)
+	// Prepared servings follow the servings of the product as sold
+	servingSizes = append(servingSizes, preparedServingSizes...)
+	if hasPrepared {
+		servingSizes = append(servingSizes, preparedReferenceServing)
+	}
+
+	for _, ss := range servingSizes {
+		issues = append(issues, validateServing(ss)...)
+	}
 
 	foodItem := &FoodItem{
 		Name:                name,
@@ -526,547 +645,134 @@ func ProcessProduct(product OpenFoodFactsProduct) (*FoodItem, error) {
 		Brand:               brand,
 		Barcode:             barcode,
 		ServingSizes:        servingSizes,
-		Allergens:           allergens,
-		IngredientAllergens: ingredientAllergens,
+		Allergens:           allergens.Values(),
+		TraceAllergens:      traceAllergens.Values(),
+		UnmappedAllergens:   unmappedAllergens.Values(),
+		UnmappedTraces:      unmappedTraces.Values(),
+		IngredientAllergens: ingredientAllergens.Values(),
 		Translations:        translations,
+
+		IngredientAllergenMatches: ingredientAllergenMatches,
+		QualityFlags:              qualityFlags(issues),
+		Issues:                    issues,
+	}
+
+	if config.AllergenProvenance {
+		provenance := NewAllergenSet()
+		provenance.Merge(allergens)
+		provenance.Merge(traceAllergens)
+		provenance.Merge(ingredientAllergens)
+		foodItem.AllergenProvenance = provenance.Provenance()
 	}
 
 	return foodItem, nil
 }
 
-func mapNutrient(field *float64, nutriments map[string]interface{}, unitConversion string, keys ...string) {
-	for _, key := range keys {
-		if value, ok := nutriments[key]; ok {
-			if fval, err := toFloat64(value); err == nil {
-				switch unitConversion {
-				case "g_to_mg":
-					*field = fval * 1000
-				case "g_to_ug":
-					*field = fval * 1e6
-				default:
-					*field = fval
-				}
-				return
-			}
+// newReferenceServing returns the 100 g serving, or the 100 ml serving for products with nutrition per 100 ml.
+// The weight of 100 ml uses the density of the product category when it is known and 1 g per ml otherwise.
+func newReferenceServing(nutritionDataPer string, food FoodDensity) ServingSize {
+	if !isVolumeBasis(nutritionDataPer) {
+		ss := ServingSize{
+			MeasurementUnit: "g",
+			Type:            1,
+			Quantity:        100,
+			WeightInGrams:   100,
+			WeightSource:    WeightSourceDeclared,
 		}
+		ss.setUnitID(UnitGram)
+		return ss
 	}
+
+	ss := ServingSize{
+		MeasurementUnit: "ml",
+		Type:            1,
+		Quantity:        100,
+		WeightInGrams:   convertToGrams(100, "ml", food),
+		WeightSource:    WeightSourceEstimated,
+	}
+	ss.setUnitID(UnitMilliliter)
+	return ss
 }
 
-func toFloat64(value interface{}) (float64, error) {
+// setUnitID sets the canonical unit of the serving with its display forms
+func (ss *ServingSize) setUnitID(unitID string) {
+	ss.UnitID = unitID
+	if names, ok := UnitDisplayNames[unitID]; ok {
+		ss.UnitSingular, ss.UnitPlural = names.Singular, names.Plural
+	}
+}
+
+// toFloat64 converts an OFF number, a JSON number or a string such as "1,5", "1.234,5 g" or "<0.5" in the locale
+func toFloat64(value interface{}, locale Locale) (float64, error) {
 	switch v := value.(type) {
 	case float64:
 		return v, nil
 	case string:
-		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return parseLocaleNumber(v, locale)
 	default:
 		return 0, fmt.Errorf("unsupported type")
 	}
 }
 
-func normalizeAllergen(allergen string) string {
-	// Convert to uppercase for case-insensitive matching
-	upperAllergen := strings.ToUpper(strings.TrimSpace(allergen))
-	upperAllergen = strings.TrimPrefix(upperAllergen, "EN:")
-
-	// Check if we have a mapping for this allergen
-	if normalized, ok := AllergenMap[upperAllergen]; ok {
-		return normalized
-	}
-
-	// Return original (but lowercase) if no mapping exists
-	return strings.ToLower(allergen)
+// parseOFFComputedNumber converts a number computed by OFF, such as product_quantity or serving_quantity.
+// OFF always writes these with a decimal point, whatever the product locale, so "28.350" is 28.35 on a German product.
+func parseOFFComputedNumber(value interface{}) (float64, error) {
+	return toFloat64(value, Locale{})
 }
 
-func extractIngredientAllergen(ingredientTag string) string {
-	// Convert to uppercase for case-insensitive matching
-	upperIngredientTag := strings.ToUpper(strings.TrimSpace(ingredientTag))
-	upperIngredientTag = strings.TrimPrefix(upperIngredientTag, "EN:")
-
-	// Check if we have a mapping for this ingredient tag
-	if normalized, ok := AllergenMap[upperIngredientTag]; ok {
-		return normalized
+// normalizeAllergen maps an allergen tag or free-text value to its standardized value.
+// Unmapped values are returned as their canonical tag with ok set to false.
+func normalizeAllergen(allergen string) (string, bool) {
+	tag := ParseTag(allergen)
+	if tag.Value == "" {
+		return "", false
 	}
 
-	return ""
-}
-func parseServingSize(servingSizeStr string) (quantity float64, measurementUnit string, weightInGrams float64, servingType int) {
-	servingSizeStr = strings.TrimSpace(servingSizeStr)
-
-	// Correct common typos or abbreviations and descriptions
-	servingSizeStr = strings.TrimSpace(servingSizeStr)
-	servingSizeStr = strings.TrimSuffix(servingSizeStr, "|")
-	servingSizeStr = strings.ReplaceAll(servingSizeStr, "OZA", "OZ")
-	servingSizeStr = strings.ReplaceAll(servingSizeStr, "OZN", "OZ")
-	servingSizeStr = strings.ReplaceAll(servingSizeStr, "ONZ", "OZ")
-	servingSizeStr = strings.ReplaceAll(servingSizeStr, "Amount per serving", "Serving")
-	servingSizeStr = strings.ReplaceAll(servingSizeStr, "FL.OZ", "FL OZ")
-
-	// Replace commas with periods for decimal numbers
-	servingSizeStr = strings.ReplaceAll(servingSizeStr, ",", ".")
-
-	// Remove multiple spaces
-	servingSizeStr = regexp.MustCompile(`\s+`).ReplaceAllString(servingSizeStr, " ")
-
-	// Remove extra characters
-	servingSizeStr = strings.Trim(servingSizeStr, "|")
-
-	// Handle descriptors like "chips", "slice", "cookie", etc.
-	servingSizeStr = handleDescriptors(servingSizeStr)
-
-	// Replace commas with periods for decimal numbers
-	servingSizeStr = strings.ReplaceAll(servingSizeStr, ",", ".")
-
-	// Handle fractions (e.g., "1/2")
-	servingSizeStr = convertFractions(servingSizeStr)
-
-	// Remove any additional information in parentheses after the main serving size
-	servingSizeStr = removeNestedParentheses(servingSizeStr)
-
-	// Pattern to match numeric value followed by "g" or "ml" (e.g., "200g", "200.0ml")
-	re := regexp.MustCompile(`^(\d*\.?\d+)\s*(g|gr|grm|gram|kg|mg|ml|l)$`)
-	matches := re.FindStringSubmatch(servingSizeStr)
-	if matches != nil {
-		qtyStr := matches[1]
-		unitStr := matches[2]
-
-		// Parse the numeric value
-		weightQty, err := strconv.ParseFloat(qtyStr, 64)
-		if err != nil {
-			// If parsing fails, fallback to default handling
-			return 1.0, servingSizeStr, 0.0, 3
-		}
-
-		// Convert the unit to grams
-		weightInGrams = convertToGrams(weightQty, unitStr)
-
-		// If weightInGrams matches the weightQty (after conversion), adjust the serving size
-		if weightInGrams == weightQty || (unitStr == "ml" && weightInGrams == weightQty) {
-			quantity = 1.0
-			measurementUnit = "Serving"
-			servingType = 3
-			return quantity, measurementUnit, weightInGrams, servingType
-		}
-	}
-
-	// Pattern: "quantity measurement_unit (weight_in_grams unit)"
-	re = regexp.MustCompile(`(?i)^(?:(\d*\.?\d+)\s+)?([^\(]+?)\s*(?:\(\s*(\d*\.?\d+)\s*(g|gr|grm|gram|kg|mg|ml|l|oz|fl oz|ounces|cup|cups)\s*\))?$`)
-	matches = re.FindStringSubmatch(servingSizeStr)
-	if matches != nil {
-		qtyStr := matches[1]
-		unitStr := matches[2]
-		weightQtyStr := matches[3]
-		weightUnit := strings.ToLower(matches[4])
-
-		// Parse quantity
-		var err error
-		if qtyStr == "" {
-			quantity = 1.0
-		} else {
-			quantity, err = strconv.ParseFloat(strings.TrimSpace(qtyStr), 64)
-			if err != nil {
-				quantity = 1.0
-			}
-		}
-
-		measurementUnit = strings.TrimSpace(unitStr)
-		measurementUnit = strings.TrimSuffix(measurementUnit, " e")
-
-		// Parse weight
-		weightInGrams = 0.0
-		if weightQtyStr != "" && weightUnit != "" {
-			weightQty, err := strconv.ParseFloat(strings.TrimSpace(weightQtyStr), 64)
-			if err == nil {
-				weightInGrams = convertToGrams(weightQty, weightUnit)
-			}
-		}
-
-		// If weightInGrams is still zero, try to extract from measurementUnit
-		if weightInGrams == 0.0 {
-			weightInGrams = extractWeightFromMeasurementUnit(measurementUnit)
-		}
-
-		// If weightInGrams is still zero, estimate based on unit
-		if weightInGrams == 0.0 {
-			estimatedWeight := estimateWeightFromUnit(quantity, measurementUnit)
-			weightInGrams = estimatedWeight
-		}
-
-		// Determine servingType
-		lowerUnit := strings.ToLower(measurementUnit)
-		if isMetricUnit(lowerUnit) {
-			servingType = 1
-		} else if isImperialUnit(lowerUnit) {
-			servingType = 2
-		} else {
-			servingType = 3
-		}
-
-		return quantity, measurementUnit, weightInGrams, servingType
-	}
-
-	// Pattern: "quantity measurement_unit (weight_in_grams g)"
-	re = regexp.MustCompile(`(?i)^(?:(\d*\.?\d+)\s+([^\(]+?))\s*(?:\(\s*(\d*\.?\d+)\s*(g|gr|grm|gram|kg|mg|ml|l|oz|fl oz|ounces)\s*\))?$`)
-	matches = re.FindStringSubmatch(servingSizeStr)
-	if matches != nil {
-		qtyStr := matches[1]
-		unitStr := matches[2]
-		weightQtyStr := matches[3]
-		weightUnit := strings.ToLower(matches[4])
-
-		// Parse quantity
-		var err error
-		quantity, err = strconv.ParseFloat(strings.TrimSpace(qtyStr), 64)
-		if err != nil {
-			quantity = 1.0
-		}
-
-		measurementUnit = strings.TrimSpace(unitStr)
-
-		// Parse weight
-		weightInGrams = 0.0
-		if weightQtyStr != "" && weightUnit != "" {
-			weightQty, err := strconv.ParseFloat(strings.TrimSpace(weightQtyStr), 64)
-			if err == nil {
-				weightInGrams = convertToGrams(weightQty, weightUnit)
-			}
-		}
-
-		// If weightInGrams is still zero, try to estimate it
-		if weightInGrams == 0.0 {
-			estimatedWeight := estimateWeightFromUnit(quantity, measurementUnit)
-			weightInGrams = estimatedWeight
-		}
-
-		// Determine servingType
-		lowerUnit := strings.ToLower(measurementUnit)
-		if isMetricUnit(lowerUnit) {
-			servingType = 1
-		} else if isImperialUnit(lowerUnit) {
-			servingType = 2
-		} else {
-			servingType = 3
-		}
-
-		return quantity, measurementUnit, weightInGrams, servingType
-	}
-
-	// Pattern: "1 BOTTLE (295 ml)" or "1 Tbsp (15 ml)" or "8 OZ (240 ml)"
-	re = regexp.MustCompile(`(?i)^(?:(\d*\.?\d+)\s+([^\(]+?))\s*\(\s*(\d*\.?\d+)\s*(g|gr|grm|gram|kg|ml|l|oz|fl oz|ounces)\s*\)$`)
-	matches = re.FindStringSubmatch(servingSizeStr)
-	if matches != nil {
-		qtyStr := matches[1]
-		unitStr := matches[2]
-		weightQtyStr := matches[3]
-		weightUnit := strings.ToLower(matches[4])
-
-		// Parse quantity
-		var err error
-		quantity, err = strconv.ParseFloat(strings.TrimSpace(qtyStr), 64)
-		if err != nil {
-			quantity = 1.0
-		}
-
-		measurementUnit = strings.TrimSpace(unitStr)
-
-		// Parse weight
-		weightQty, err := strconv.ParseFloat(strings.TrimSpace(weightQtyStr), 64)
-		if err != nil {
-			weightQty = 0.0
-		}
-
-		// Convert weight to grams
-		weightInGrams = convertToGrams(weightQty, weightUnit)
-
-		// Determine servingType
-		lowerUnit := strings.ToLower(measurementUnit)
-		if isMetricUnit(lowerUnit) {
-			servingType = 1
-		} else if isImperialUnit(lowerUnit) {
-			servingType = 2
-		} else {
-			servingType = 3
-		}
-
-		return quantity, measurementUnit, weightInGrams, servingType
-	}
-
-	// Pattern: "1 slice 1 oz / 28 g"
-	re = regexp.MustCompile(`(?i)^(?:(\d*\.?\d+)\s+([^\(]+?))\s+(\d*\.?\d+)\s*(oz|ounces)\s*/\s*(\d*\.?\d+)\s*(g|gr|grm|gram)$`)
-	matches = re.FindStringSubmatch(servingSizeStr)
-	if matches != nil {
-		qtyStr := matches[1]
-		unitStr := matches[2]
-		gQtyStr := matches[5]
-
-		// Parse quantity
-		var err error
-		quantity, err = strconv.ParseFloat(strings.TrimSpace(qtyStr), 64)
-		if err != nil {
-			quantity = 1.0
-		}
-
-		measurementUnit = strings.TrimSpace(unitStr)
-
-		// Use grams directly
-		weightQty, err := strconv.ParseFloat(strings.TrimSpace(gQtyStr), 64)
-		if err != nil {
-			weightQty = 0.0
-		}
-
-		weightInGrams = weightQty
-
-		// Determine servingType
-		servingType = 3 // Non-standard unit
-
-		return quantity, measurementUnit, weightInGrams, servingType
-	}
-
-	// Regular expression to match patterns like "1.5 g (1 TEA BAG)"
-	re = regexp.MustCompile(`(?i)^(?:(\d*\.?\d+)\s*(g|gr|grm|gram|ml|l|oz|fl oz))\s*\(\s*(\d*\.?\d+)?\s*([^\)]+)\s*\)$`)
-	matches = re.FindStringSubmatch(servingSizeStr)
-	if matches != nil {
-		// Extract weight in grams
-		weightQtyStr := matches[1]
-		weightUnit := strings.ToLower(matches[2])
-
-		var weightQty float64
-		var err error
-		weightQty, err = strconv.ParseFloat(strings.TrimSpace(weightQtyStr), 64)
-		if err != nil {
-			weightQty = 0.0
-		}
-
-		// Convert weight to grams if necessary
-		weightInGrams = convertToGrams(weightQty, weightUnit)
-
-		// Extract quantity and measurement unit from parentheses
-		qtyStr := matches[3]
-		unitStr := matches[4]
-
-		if qtyStr == "" {
-			quantity = 1.0
-		} else {
-			quantity, err = strconv.ParseFloat(strings.TrimSpace(qtyStr), 64)
-			if err != nil {
-				quantity = 1.0
-			}
-		}
-
-		measurementUnit = strings.TrimSpace(unitStr)
-
-		// Determine servingType
-		lowerUnit := strings.ToLower(measurementUnit)
-		if isMetricUnit(lowerUnit) {
-			servingType = 1
-		} else if isImperialUnit(lowerUnit) {
-			servingType = 2
-		} else {
-			servingType = 3
-		}
-
-		return quantity, measurementUnit, weightInGrams, servingType
+	if normalized, ok := lookupAllergenTag(tag); ok {
+		return normalized, true
 	}
 
-	// Regular expression to match patterns like "2 SLICES (57 g)"
-	re = regexp.MustCompile(`(?i)^(?:(\d*\.?\d+)\s+([^\(]+))\s*\(\s*(\d*\.?\d+)\s*(?:g|gr|grm|gram)\s*\)$`)
-	matches = re.FindStringSubmatch(servingSizeStr)
-	if matches != nil {
-		qtyStr := matches[1]
-		unitStr := matches[2]
-		weightStr := matches[3]
-
-		// Parse quantity
-		var err error
-		quantity, err = strconv.ParseFloat(strings.TrimSpace(qtyStr), 64)
-		if err != nil {
-			quantity = 1.0
-		}
-
-		// Parse measurement unit
-		measurementUnit = strings.TrimSpace(unitStr)
-
-		// Parse weight in grams
-		weightInGrams, err = strconv.ParseFloat(strings.TrimSpace(weightStr), 64)
-		if err != nil {
-			weightInGrams = 0.0
-		}
-
-		// Determine servingType
-		lowerUnit := strings.ToLower(measurementUnit)
-		if isMetricUnit(lowerUnit) {
-			servingType = 1
-		} else if isImperialUnit(lowerUnit) {
-			servingType = 2
-		} else {
-			servingType = 3
-		}
-
-		return quantity, measurementUnit, weightInGrams, servingType
-	}
-
-	// Regular expression to match patterns like "30 g (30 GRM)"
-	re = regexp.MustCompile(`(?i)^(?:(\d*\.?\d+)\s*(g|gr|grm|gram|ml|oz|fl oz))\s*\(\s*(\d*\.?\d+)\s*(?:g|gr|grm|gram)\s*\)$`)
-	matches = re.FindStringSubmatch(servingSizeStr)
-	if matches != nil {
-		qtyStr := matches[1]
-		unitStr := matches[2]
-		weightStr := matches[3]
-
-		// Parse quantity
-		var err error
-		quantity, err = strconv.ParseFloat(strings.TrimSpace(qtyStr), 64)
-		if err != nil {
-			quantity = 1.0
-		}
-
-		// Parse measurement unit
-		measurementUnit = strings.TrimSpace(unitStr)
-
-		// Parse weight in grams
-		weightInGrams, err = strconv.ParseFloat(strings.TrimSpace(weightStr), 64)
-		if err != nil {
-			weightInGrams = 0.0
-		}
-
-		// Determine servingType
-		lowerUnit := strings.ToLower(measurementUnit)
-		if isMetricUnit(lowerUnit) {
-			servingType = 1
-		} else if isImperialUnit(lowerUnit) {
-			servingType = 2
-		} else {
-			servingType = 3
-		}
-
-		return quantity, measurementUnit, weightInGrams, servingType
-	}
-
-	// Pattern for "unit X g"
-	reUnit := regexp.MustCompile(`(?i)^([^\s]+)\s+(\d*\.?\d+)\s*(g|gr|grm|gram|ml|oz|fl oz)$`)
-	matches = reUnit.FindStringSubmatch(servingSizeStr)
-	if matches != nil {
-		unitStr := matches[1]
-		qtyStr := matches[2]
-		weightUnit := matches[3]
-
-		quantity, err := strconv.ParseFloat(strings.TrimSpace(qtyStr), 64)
-		if err != nil {
-			quantity = 1.0
-		}
-
-		measurementUnit = strings.TrimSpace(unitStr)
-		weightInGrams = convertToGrams(quantity, weightUnit)
-
-		lowerUnit := strings.ToLower(measurementUnit)
-		if isMetricUnit(lowerUnit) {
-			servingType = 1
-		} else if isImperialUnit(lowerUnit) {
-			servingType = 2
-		} else {
-			servingType = 3
-		}
+	return tag.String(), false
+}
 
-		return quantity, measurementUnit, weightInGrams, servingType
+func extractIngredientAllergen(ingredientTag string) string {
+	tag := ParseTag(ingredientTag)
+	if tag.Value == "" {
+		return ""
 	}
 
-	// Pattern for "X g"
-	reSimple := regexp.MustCompile(`(?i)^(\d*\.?\d+)\s*(g|gr|grm|gram|ml|oz|fl oz)$`)
-	matches = reSimple.FindStringSubmatch(servingSizeStr)
-	if matches != nil {
-		qtyStr := matches[1]
-		unitStr := matches[2]
-
-		var err error
-		quantity, err = strconv.ParseFloat(strings.TrimSpace(qtyStr), 64)
-		if err != nil {
-			quantity = 1.0
-		}
-
-		measurementUnit = strings.TrimSpace(unitStr)
-		weightInGrams = quantity
-
-		lowerUnit := strings.ToLower(measurementUnit)
-		if isMetricUnit(lowerUnit) {
-			servingType = 1
-		} else if isImperialUnit(lowerUnit) {
-			servingType = 2
-		} else {
-			servingType = 3
-		}
-
-		return quantity, measurementUnit, weightInGrams, servingType
+	if normalized, ok := lookupAllergenTag(tag); ok {
+		return normalized
 	}
 
-	// Default handling
-	quantity = 1.0
-	measurementUnit = servingSizeStr
-	servingType = 3
-	weightInGrams = 0.0
-
-	return quantity, measurementUnit, weightInGrams, servingType
+	return ""
 }
 
-// Convert fractions to decimal numbers
-func convertFractions(input string) string {
-	re := regexp.MustCompile(`(\d+)\s*/\s*(\d+)`)
-	matches := re.FindAllStringSubmatch(input, -1)
-	for _, match := range matches {
-		numerator, _ := strconv.ParseFloat(match[1], 64)
-		denominator, _ := strconv.ParseFloat(match[2], 64)
-		if denominator != 0 {
-			decimalValue := numerator / denominator
-			input = strings.Replace(input, match[0], fmt.Sprintf("%.4f", decimalValue), -1)
-		}
+// lookupAllergenTag checks AllergenMap first, so the standardized values and values that are spelled the same in
+// every language are never remapped by a taxonomy, then the multilingual taxonomy synonyms (e.g. "fr:lait")
+func lookupAllergenTag(tag Tag) (string, bool) {
+	// Convert to uppercase for case-insensitive matching, hyphens and underscores as spaces
+	if normalized, ok := AllergenMap[tag.MapKey()]; ok {
+		return normalized, true
 	}
-	return input
-}
 
-// Remove nested parentheses beyond the first level
-func removeNestedParentheses(input string) string {
-	re := regexp.MustCompile(`\([^()]*\)`)
-	matches := re.FindAllStringIndex(input, -1)
-	if len(matches) > 1 {
-		// Keep only the first match
-		input = input[:matches[0][1]]
+	lang := tag.Lang
+	if lang == "" {
+		lang = "en"
 	}
-	return input
-}
-
-// Handle descriptors like "chips", "slice", "cookie"
-func handleDescriptors(input string) string {
-	descriptors := []string{"chips", "slice", "slices", "cookie", "cookies", "pouch", "pouches", "can", "cans", "bottle", "bottles", "box", "boxes", "bag", "bags", "piece", "pieces"}
-	for _, desc := range descriptors {
-		re := regexp.MustCompile(`(?i)^` + desc + `\s+`)
-		input = re.ReplaceAllString(input, "")
+	if normalized, ok := AllergenSynonyms[taxonomyKey(lang, tag.Value)]; ok {
+		return normalized, true
 	}
-	return input
-}
 
-// Extract weight from measurementUnit if possible
-func extractWeightFromMeasurementUnit(measurementUnit string) float64 {
-	re := regexp.MustCompile(`(?i)(\d*\.?\d+)\s*(g|gr|grm|gram|kg|mg|ml|l|oz|ounces|fl oz)`)
-	matches := re.FindStringSubmatch(measurementUnit)
-	if matches != nil {
-		weightQtyStr := matches[1]
-		weightUnit := matches[2]
-		weightQty, err := strconv.ParseFloat(strings.TrimSpace(weightQtyStr), 64)
-		if err == nil {
-			return convertToGrams(weightQty, weightUnit)
-		}
-	}
-	return 0.0
+	return "", false
 }
 
-// Estimate weight based on measurement unit
-func estimateWeightFromUnit(quantity float64, unit string) float64 {
+// Estimate weight based on measurement unit, household measures use the density of the food
+func estimateWeightFromUnit(quantity float64, unit string, food FoodDensity) float64 {
 	lowerUnit := strings.ToLower(unit)
 	switch lowerUnit {
-	case "cup", "cups":
-		return quantity * 240 // 1 cup ~ 240g
-	case "tbsp", "tablespoon", "tablespoons":
-		return quantity * 15 // 1 tbsp ~ 15g
-	case "tsp", "teaspoon", "teaspoons":
-		return quantity * 5 // 1 tsp ~ 5g
+	case "cup", "cups", "tbsp", "tablespoon", "tablespoons", "tsp", "teaspoon", "teaspoons":
+		return quantity * food.householdMeasureGrams(lowerUnit)
 	case "slice", "slices":
 		return quantity * 28 // 1 slice ~ 28g
 	case "cookie", "cookies":
@@ -1077,8 +783,8 @@ func estimateWeightFromUnit(quantity float64, unit string) float64 {
 	}
 }
 
-// Convert units to grams
-func convertToGrams(quantity float64, unit string) float64 {
+// Convert units to grams, volumes are converted with the density of the food
+func convertToGrams(quantity float64, unit string, food FoodDensity) float64 {
 	switch strings.ToLower(unit) {
 	case "g", "gram", "grams", "gr", "grm", "g.", "gr.", "grm.":
 		return quantity
@@ -1091,9 +797,17 @@ func convertToGrams(quantity float64, unit string) float64 {
 	case "lb", "pound", "pounds":
 		return quantity * 453.592
 	case "ml":
-		return quantity // Assuming 1 g/ml
+		return quantity * food.Density
+	case "cl":
+		return quantity * 10 * food.Density
+	case "dl":
+		return quantity * 100 * food.Density
 	case "l", "liter", "litre", "liters", "litres":
-		return quantity * 1000
+		return quantity * 1000 * food.Density
+	case "fl oz", "fl. oz", "fl.oz", "fl_oz":
+		return quantity * ML_PER_FL_OZ * food.Density
+	case "cup", "cups", "tbsp", "tablespoon", "tablespoons", "tsp", "teaspoon", "teaspoons":
+		return quantity * food.householdMeasureGrams(unit)
 	default:
 		return 0.0
 	}
@@ -1101,7 +815,7 @@ func convertToGrams(quantity float64, unit string) float64 {
 
 // Check if the unit is a metric unit
 func isMetricUnit(unit string) bool {
-	metricUnits := []string{"g", "gram", "grams", "gr", "grm", "g.", "gr.", "grm.", "kg", "kilogram", "kilograms", "ml", "l", "liter", "litre", "liters", "litres"}
+	metricUnits := []string{"g", "gram", "grams", "gr", "grm", "g.", "gr.", "grm.", "kg", "kilogram", "kilograms", "mg", "ml", "cl", "dl", "l", "liter", "litre", "liters", "litres"}
 	for _, u := range metricUnits {
 		if unit == u {
 			return true
@@ -1112,7 +826,7 @@ func isMetricUnit(unit string) bool {
 
 // Check if the unit is an imperial unit
 func isImperialUnit(unit string) bool {
-	imperialUnits := []string{"oz", "ounce", "ounces", "onz", "ozn", "oza", "lb", "pound", "pounds", "fl oz"}
+	imperialUnits := []string{"oz", "ounce", "ounces", "onz", "ozn", "oza", "lb", "pound", "pounds", "fl oz", "fl_oz"}
 	for _, u := range imperialUnits {
 		if unit == u {
 			return true
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// NutrientDefinition describes how a nutrient is read from the OFF nutriments and written to ServingSize
+type NutrientDefinition struct {
+	Field  string  `json:"field"`            // Output field, e.g. "vitamin_c"
+	Key    string  `json:"key"`              // OFF nutriments key stem, e.g. "vitamin-c" for "vitamin-c_100g"
+	Unit   string  `json:"unit"`             // Canonical output unit: "g", "mg", "µg", "IU" or "kcal"
+	Factor float64 `json:"factor,omitempty"` // Multiplier applied to the OFF value, derived from Unit when empty
+
+	// IUToMicrograms converts international units of vitamins, e.g. 0.025 for vitamin D
+	IUToMicrograms float64 `json:"iu_to_ug,omitempty"`
+}
+
+// Output fields that are used in calculations
+const (
+	NutrientCalories = "calories"
+	NutrientProtein  = "protein"
+	NutrientFat      = "fat"
+	NutrientCarbs    = "carbs"
+	NutrientSodium   = "sodium"
+	NutrientSalt     = "salt"
+	NutrientAlcohol  = "alcohol"
+)
+
+// NutrientRegistry lists every nutrient in output order. OFF stores masses in grams, so the
+// factor converts grams into the canonical unit.
+var NutrientRegistry = []NutrientDefinition{
+	{Field: NutrientCalories, Key: "energy-kcal", Unit: "kcal"},
+	{Field: NutrientProtein, Key: "proteins", Unit: "g"},
+	{Field: NutrientFat, Key: "fat", Unit: "g"},
+	{Field: NutrientCarbs, Key: "carbohydrates", Unit: "g"},
+	{Field: "fiber", Key: "fiber", Unit: "g"},
+	{Field: "sugar", Key: "sugars", Unit: "g"},
+	{Field: NutrientSodium, Key: "sodium", Unit: "mg"},
+	{Field: "cholesterol", Key: "cholesterol", Unit: "mg"},
+	{Field: "calcium", Key: "calcium", Unit: "mg"},
+	{Field: "iron", Key: "iron", Unit: "mg"},
+	{Field: "potassium", Key: "potassium", Unit: "mg"},
+	{Field: "magnesium", Key: "magnesium", Unit: "mg"},
+	{Field: "zinc", Key: "zinc", Unit: "mg"},
+	{Field: "vitamin_a_iu", Key: "vitamin-a_iu", Unit: "IU", IUToMicrograms: 0.3},
+	{Field: "vitamin_c", Key: "vitamin-c", Unit: "mg"},
+	{Field: "vitamin_d", Key: "vitamin-d", Unit: "µg", IUToMicrograms: 0.025},
+	{Field: "vitamin_d_iu", Key: "vitamin-d_iu", Unit: "IU", IUToMicrograms: 0.025},
+	{Field: "vitamin_e", Key: "vitamin-e", Unit: "mg"},
+	{Field: "vitamin_k", Key: "vitamin-k", Unit: "µg"},
+	{Field: "thiamin", Key: "thiamin", Unit: "mg"},
+	{Field: "riboflavin", Key: "riboflavin", Unit: "mg"},
+	{Field: "niacin", Key: "niacin", Unit: "mg"},
+	{Field: "vitamin_b6", Key: "vitamin-b6", Unit: "mg"},
+	{Field: "folate", Key: "folate", Unit: "µg"},
+	{Field: "vitamin_b12", Key: "vitamin-b12", Unit: "µg"},
+	{Field: "phosphorus", Key: "phosphorus", Unit: "mg"},
+	{Field: "copper", Key: "copper", Unit: "mg"},
+	{Field: "manganese", Key: "manganese", Unit: "mg"},
+	{Field: "selenium", Key: "selenium", Unit: "µg"},
+	{Field: "water", Key: "water", Unit: "g"},
+	{Field: "ash", Key: "ash", Unit: "g"},
+	{Field: "saturated_fat", Key: "saturated-fat", Unit: "g"},
+	{Field: "monounsaturated_fat", Key: "monounsaturated-fat", Unit: "g"},
+	{Field: "polyunsaturated_fat", Key: "polyunsaturated-fat", Unit: "g"},
+	{Field: "trans_fat", Key: "trans-fat", Unit: "g"},
+	{Field: NutrientSalt, Key: "salt", Unit: "g"},
+	{Field: "added_sugars", Key: "added-sugars", Unit: "g"},
+	{Field: NutrientAlcohol, Key: "alcohol", Unit: "% vol"},
+	{Field: "caffeine", Key: "caffeine", Unit: "mg"},
+	{Field: "starch", Key: "starch", Unit: "g"},
+	{Field: "polyols", Key: "polyols", Unit: "g"},
+	{Field: "omega_3_fat", Key: "omega-3-fat", Unit: "g"},
+	{Field: "omega_6_fat", Key: "omega-6-fat", Unit: "g"},
+	{Field: "iodine", Key: "iodine", Unit: "µg"},
+	{Field: "chloride", Key: "chloride", Unit: "mg"},
+	{Field: "biotin", Key: "biotin", Unit: "µg"},
+	{Field: "pantothenic_acid", Key: "pantothenic-acid", Unit: "mg"},
+}
+
+// loadNutrientRegistry extends NutrientRegistry from a JSON array of definitions. Definitions with
+// an existing field replace it, new fields are appended to the output.
+func loadNutrientRegistry(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var definitions []NutrientDefinition
+	err = json.Unmarshal(data, &definitions)
+	if err != nil {
+		return fmt.Errorf("invalid nutrient definitions in %s: %v", path, err)
+	}
+
+	for _, definition := range definitions {
+		if definition.Field == "" || definition.Key == "" {
+			return fmt.Errorf("nutrient definition in %s needs both field and key", path)
+		}
+		replaced := false
+		for i, existing := range NutrientRegistry {
+			if existing.Field == definition.Field {
+				NutrientRegistry[i] = definition
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			NutrientRegistry = append(NutrientRegistry, definition)
+		}
+	}
+
+	return nil
+}
+
+// factor returns the multiplier from the grams used by OFF into the canonical unit
+func (d NutrientDefinition) factor() float64 {
+	if d.Factor != 0 {
+		return d.Factor
+	}
+	switch d.Unit {
+	case "mg":
+		return 1000
+	case "µg", "ug", "mcg":
+		return 1e6
+	default:
+		return 1
+	}
+}
+
+// Grams per unit for the mass units OFF uses
+var nutrientMassUnits = map[string]float64{
+	"g":  1,
+	"mg": 1e-3,
+	"µg": 1e-6,
+}
+
+// normalizeNutrientUnit maps the spellings of OFF <nutrient>_unit values to a known unit
+func normalizeNutrientUnit(unit string) (string, bool) {
+	switch strings.ToLower(strings.Join(strings.Fields(unit), " ")) {
+	case "g", "gr", "gram", "grams":
+		return "g", true
+	case "mg", "milligram", "milligrams":
+		return "mg", true
+	case "µg", "μg", "ug", "mcg", "microgram", "micrograms":
+		return "µg", true
+	case "iu", "ui", "ie":
+		return "IU", true
+	case "kcal":
+		return "kcal", true
+	case "kj":
+		return "kJ", true
+	case "% dv", "%dv", "% vd", "%vd":
+		return "% DV", true
+	case "%":
+		return "%", true
+	case "% vol", "%vol", "° vol", "°":
+		return "% vol", true
+	default:
+		return "", false
+	}
+}
+
+// convert converts a value in the given unit into the canonical unit of the nutrient
+func (d NutrientDefinition) convert(value float64, unit string) (float64, bool) {
+	canonical, ok := normalizeNutrientUnit(d.Unit)
+	if !ok {
+		canonical = d.Unit
+	}
+
+	if unit == "g" && d.Factor != 0 {
+		return value * d.Factor, true
+	}
+	if unit == canonical {
+		return value, true
+	}
+
+	grams, fromMass := nutrientMassUnits[unit]
+	if unit == "IU" && d.IUToMicrograms != 0 {
+		grams, fromMass = d.IUToMicrograms*1e-6, true
+	}
+	if !fromMass {
+		return 0, false
+	}
+
+	if canonicalGrams, ok := nutrientMassUnits[canonical]; ok {
+		return value * grams / canonicalGrams, true
+	}
+	if canonical == "IU" && d.IUToMicrograms != 0 {
+		return value * grams / (d.IUToMicrograms * 1e-6), true
+	}
+	return 0, false
+}
+
+// nutrientSourceUnit returns the unit the OFF <key><suffix> value is expressed in. OFF normally converts
+// masses, international units and % DV into grams and keeps <key>_unit and <key>_value as entered.
+// When the stored value equals <key>_value for another unit, the value was not converted.
+func nutrientSourceUnit(nutriments map[string]interface{}, definition NutrientDefinition, key string, value float64) (string, error) {
+	rawUnit, hasUnit := nutriments[key+"_unit"].(string)
+	if !hasUnit || strings.TrimSpace(rawUnit) == "" {
+		return "g", nil
+	}
+
+	unit, known := normalizeNutrientUnit(rawUnit)
+	if !known {
+		return "", fmt.Errorf("unknown unit %q", rawUnit)
+	}
+
+	// A plain percentage is the alcohol content for % vol nutrients and a daily value otherwise
+	if unit == "%" {
+		unit = "% DV"
+		if definition.Unit == "% vol" {
+			unit = "% vol"
+		}
+	}
+
+	switch unit {
+	case "kcal", "kJ", "% vol":
+		// Stored as entered
+		return unit, nil
+	case "g":
+		return "g", nil
+	}
+
+	if entered, ok := nutrimentValue(nutriments, key+"_value"); ok && value != 0 && math.Abs(entered-value) <= 1e-9*math.Abs(value) {
+		if unit == "% DV" {
+			return "", fmt.Errorf("value in %s was not converted to grams", unit)
+		}
+		return unit, nil
+	}
+
+	return "g", nil
+}
+
+// extractNutrients fills the registry nutrients and calories of a serving from the nutriments keys with the given
+// variant and suffix, e.g. NutrimentsPrepared and "_100g" for "proteins_prepared_100g".
+// Only nutrients present in OFF are set, see estimateEnergy for missing calories. Values in unknown or
+// unconvertible units are left out and reported.
+func extractNutrients(ss *ServingSize, nutriments map[string]interface{}, variant string, suffix string) []QualityIssue {
+	issues := []QualityIssue{}
+	if ss.Nutrients == nil {
+		ss.Nutrients = make(map[string]float64)
+	}
+
+	for _, definition := range NutrientRegistry {
+		if definition.Field == NutrientCalories {
+			continue
+		}
+		key := definition.Key + variant
+		value, ok := nutrimentValue(nutriments, key+suffix)
+		if !ok {
+			continue
+		}
+
+		if _, hasUnit := nutriments[key+"_unit"]; !hasUnit {
+			ss.Nutrients[definition.Field] = value * definition.factor()
+			ss.setQualifier(definition.Field, nutrimentQualifier(nutriments, key))
+			continue
+		}
+
+		unit, err := nutrientSourceUnit(nutriments, definition, key, value)
+		if err != nil {
+			issues = append(issues, QualityIssue{Code: IssueUnknownUnit, Severity: SeverityWarning, Serving: servingLabel(*ss), Nutrient: definition.Field, Detail: err.Error()})
+			continue
+		}
+		converted, ok := definition.convert(value, unit)
+		if !ok {
+			issues = append(issues, QualityIssue{
+				Code:     IssueUnconvertibleUnit,
+				Severity: SeverityWarning,
+				Serving:  servingLabel(*ss),
+				Nutrient: definition.Field,
+				Detail:   fmt.Sprintf("cannot convert %s to %s", unit, definition.Unit),
+			})
+			continue
+		}
+		ss.Nutrients[definition.Field] = converted
+		ss.setQualifier(definition.Field, nutrimentQualifier(nutriments, key))
+	}
+
+	if calories, source := resolveEnergy(nutriments, variant, suffix); source != "" {
+		ss.Nutrients[NutrientCalories] = calories
+		ss.EnergySource = source
+		ss.setQualifier(NutrientCalories, nutrimentQualifier(nutriments, source+variant))
+	}
+
+	return issues
+}
+
+// completeNutrients fills the nutrients that can be calculated from the others
+func completeNutrients(ss *ServingSize) {
+	deriveSodiumSalt(ss)
+	estimateEnergy(ss)
+}
+
+// estimateEnergy estimates the calories from the macros when no energy value is known.
+// Without any macro the calories stay missing rather than becoming zero.
+func estimateEnergy(ss *ServingSize) {
+	if ss.EnergySource != "" || !hasEnergyNutrients(*ss) {
+		return
+	}
+	ss.Nutrients[NutrientCalories] = estimateCalories(*ss)
+	ss.EnergySource = EnergySourceEstimate
+}
+
+// deriveSodiumSalt calculates sodium from salt, or the reverse, when OFF only has one of them
+func deriveSodiumSalt(ss *ServingSize) {
+	sodium, hasSodium := ss.Nutrients[NutrientSodium]
+	salt, hasSalt := ss.Nutrients[NutrientSalt]
+	if hasSodium && !hasSalt {
+		ss.Nutrients[NutrientSalt] = sodium / 1000 * SALT_PER_SODIUM
+		ss.DerivedNutrients = append(ss.DerivedNutrients, NutrientSalt)
+		ss.setQualifier(NutrientSalt, ss.Qualifier(NutrientSodium))
+	} else if hasSalt && !hasSodium {
+		ss.Nutrients[NutrientSodium] = salt / SALT_PER_SODIUM * 1000
+		ss.DerivedNutrients = append(ss.DerivedNutrients, NutrientSodium)
+		ss.setQualifier(NutrientSodium, ss.Qualifier(NutrientSalt))
+	}
+}
+
+// deriveServingNutrients scales the per-100g values to the serving weight for every nutrient OFF has no
+// per-serving value for, and reports the per-serving values that disagree with the scaled values
+func deriveServingNutrients(ss *ServingSize, per100g ServingSize) []QualityIssue {
+	issues := []QualityIssue{}
+	if ss.WeightInGrams <= 0 || per100g.WeightInGrams <= 0 {
+		return issues
+	}
+	scale := ss.WeightInGrams / per100g.WeightInGrams
+
+	for _, definition := range NutrientRegistry {
+		field := definition.Field
+		reference, ok := per100g.Nutrients[field]
+		if !ok {
+			continue
+		}
+		scaled := reference * scale
+		if definition.Unit == "% vol" {
+			// Concentrations don't depend on the serving weight
+			scaled = reference
+		}
+
+		value, ok := ss.Nutrients[field]
+		if !ok {
+			ss.Nutrients[field] = scaled
+			ss.DerivedNutrients = append(ss.DerivedNutrients, field)
+			ss.setQualifier(field, per100g.Qualifier(field))
+			if field == NutrientCalories {
+				ss.EnergySource = per100g.EnergySource
+			}
+			continue
+		}
+
+		// Bounds such as "<0.5" can't disagree with an exact value
+		if ss.Qualifier(field) != QualifierExact || per100g.Qualifier(field) != QualifierExact {
+			continue
+		}
+
+		difference := math.Abs(value - scaled)
+		if difference > SERVING_ABSOLUTE_TOLERANCE && difference > SERVING_RELATIVE_TOLERANCE*math.Max(value, scaled) {
+			issues = append(issues, QualityIssue{
+				Code:     IssueServingMismatch,
+				Severity: SeverityWarning,
+				Serving:  servingLabel(*ss),
+				Nutrient: field,
+				Detail:   fmt.Sprintf("per-serving value %g %s, per-100g value scaled to %g g is %g %s", value, definition.Unit, ss.WeightInGrams, scaled, definition.Unit),
+			})
+		}
+	}
+
+	return issues
+}
+
+// Nutrient returns the value of a registry field, zero when it is missing
+func (ss ServingSize) Nutrient(field string) float64 {
+	return ss.Nutrients[field]
+}
+
+// Qualifier returns the qualifier of a registry field, QualifierExact unless one was recorded
+func (ss ServingSize) Qualifier(field string) string {
+	if qualifier, ok := ss.NutrientQualifiers[field]; ok {
+		return qualifier
+	}
+	return QualifierExact
+}
+
+// setQualifier records the qualifier of a nutrient value, exact values are not recorded
+func (ss *ServingSize) setQualifier(field string, qualifier string) {
+	if qualifier == QualifierExact {
+		delete(ss.NutrientQualifiers, field)
+		return
+	}
+	if ss.NutrientQualifiers == nil {
+		ss.NutrientQualifiers = make(map[string]string)
+	}
+	ss.NutrientQualifiers[field] = qualifier
+}
+
+// MarshalJSON writes the nutrients as top-level fields in registry order, after the serving fields.
+// Missing nutrients are omitted while reported zeros are written, unless the legacy omitempty shape is configured.
+func (ss ServingSize) MarshalJSON() ([]byte, error) {
+	type servingFields ServingSize
+	data, err := marshalUnescaped(servingFields(ss))
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := bytes.NewBuffer(data[:len(data)-1])
+	for _, definition := range NutrientRegistry {
+		value, ok := ss.Nutrients[definition.Field]
+		if !ok || (config.LegacyOmitEmpty && value == 0) {
+			continue
+		}
+		encoded, err := marshalUnescaped(value)
+		if err != nil {
+			return nil, fmt.Errorf("nutrient %s: %v", definition.Field, err)
+		}
+		fmt.Fprintf(buffer, ",%q:%s", definition.Field, encoded)
+	}
+	buffer.WriteByte('}')
+
+	return buffer.Bytes(), nil
+}
+
+// marshalUnescaped encodes a value like json.Marshal without escaping HTML characters, e.g. "<" in a serving unit
+func marshalUnescaped(value interface{}) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+const KJ_PER_KCAL = 4.184
+
+// Nutriments key variants, e.g. "proteins_100g" for the product as sold and "proteins_prepared_100g" once prepared
+const (
+	NutrimentsAsSold   = ""
+	NutrimentsPrepared = "_prepared"
+)
+
+// Grams of ethanol per ml, to convert the alcohol content in % vol into grams
+const ALCOHOL_DENSITY = 0.789
+
+// Grams of salt per gram of sodium
+const SALT_PER_SODIUM = 2.5
+
+// Relative and absolute differences tolerated between OFF per-serving values and scaled per-100g values
+const SERVING_RELATIVE_TOLERANCE = 0.15
+const SERVING_ABSOLUTE_TOLERANCE = 1.0
+
+// Energy sources recorded in ServingSize.EnergySource
+const (
+	EnergySourceKcal     = "energy-kcal"
+	EnergySourceKj       = "energy-kj"
+	EnergySourceEnergy   = "energy"
+	EnergySourceEstimate = "estimate"
+)
+
+// resolveEnergy returns the energy in kcal for a key variant and suffix such as "_100g" or "_serving" and the OFF field it came from.
+// The kcal field is preferred, then the kJ field, then the generic energy field in the unit given by energy_unit.
+func resolveEnergy(nutriments map[string]interface{}, variant string, suffix string) (float64, string) {
+	if kcal, ok := nutrimentValue(nutriments, "energy-kcal"+variant+suffix); ok {
+		return kcal, EnergySourceKcal
+	}
+
+	if kj, ok := nutrimentValue(nutriments, "energy-kj"+variant+suffix); ok {
+		return kj / KJ_PER_KCAL, EnergySourceKj
+	}
+
+	if energy, ok := nutrimentValue(nutriments, "energy"+variant+suffix); ok {
+		if isKcalEnergy(nutriments, variant, energy) {
+			return energy, EnergySourceEnergy
+		}
+		return energy / KJ_PER_KCAL, EnergySourceEnergy
+	}
+
+	return 0, ""
+}
+
+// isKcalEnergy reports whether the generic energy value is in kcal. OFF usually stores it in kJ and
+// energy_unit describes energy_value, so a kcal unit is only trusted when the value was not converted.
+func isKcalEnergy(nutriments map[string]interface{}, variant string, energy float64) bool {
+	unit, _ := nutriments["energy"+variant+"_unit"].(string)
+	if strings.ToLower(strings.TrimSpace(unit)) != "kcal" {
+		return false
+	}
+	if value, ok := nutrimentValue(nutriments, "energy"+variant+"_value"); ok && value > 0 {
+		return math.Abs(energy-value*KJ_PER_KCAL) > 0.01*energy
+	}
+	return true
+}
+
+// estimateCalories uses the Atwater factors when OFF has no energy value. The alcohol content
+// in % vol is converted to grams assuming the product weighs about 1 g per ml.
+func estimateCalories(ss ServingSize) float64 {
+	alcohol := ss.Nutrient(NutrientAlcohol) / 100 * ss.WeightInGrams * ALCOHOL_DENSITY
+	return ss.Nutrient(NutrientProtein)*4 + ss.Nutrient(NutrientFat)*9 + ss.Nutrient(NutrientCarbs)*4 + alcohol*7
+}
+
+// hasEnergyNutrients reports whether any nutrient used by estimateCalories is known
+func hasEnergyNutrients(ss ServingSize) bool {
+	return hasNutrient(ss, NutrientProtein) || hasNutrient(ss, NutrientFat) || hasNutrient(ss, NutrientCarbs) || hasNutrient(ss, NutrientAlcohol)
+}
+
+// normalizeNutriments returns the nutriments with numeric strings, e.g. "1,5" or "12 g", parsed in the locale.
+// Values such as "<0.5" or "traces" set the _modifier of the nutrient when OFF has none. A unit after a _100g or
+// _serving value, e.g. "40 mg", is converted into the unit OFF stores these values in, and values whose unit cannot
+// be converted are left out and reported.
+func normalizeNutriments(nutriments map[string]interface{}, locale Locale) (map[string]interface{}, []QualityIssue) {
+	normalized := make(map[string]interface{}, len(nutriments))
+	issues := []QualityIssue{}
+	for key, value := range nutriments {
+		normalized[key] = value
+		text, ok := value.(string)
+		if !ok || strings.HasSuffix(key, "_unit") || strings.HasSuffix(key, "_modifier") {
+			continue
+		}
+		number, qualifier, unit, err := parseQualifiedNumber(text, locale)
+		if err != nil {
+			continue
+		}
+
+		// _value keys are as entered, their unit is in the _unit key
+		if unit != "" && !strings.HasSuffix(key, "_value") {
+			converted, code, err := nutrimentBaseValue(key, number, unit)
+			if err != nil {
+				delete(normalized, key)
+				issues = append(issues, QualityIssue{Code: code, Severity: SeverityWarning, Nutrient: key, Detail: fmt.Sprintf("%q: %v", text, err)})
+				continue
+			}
+			number = converted
+		}
+
+		normalized[key] = number
+		if modifierKey := nutrimentModifierKey(key); qualifier != QualifierExact && nutriments[modifierKey] == nil {
+			normalized[modifierKey] = qualifier
+		}
+	}
+	return normalized, issues
+}
+
+// nutrimentBaseValue converts a value of a _100g or _serving key written with a unit into the unit OFF stores the
+// key in: kcal for energy-kcal, kJ for energy-kj and energy, % vol for alcohol and grams for every other nutrient.
+// Percentages are only accepted for alcohol, for other nutrients they are daily values. The issue code for values that cannot be converted is returned with the error.
+func nutrimentBaseValue(key string, value float64, rawUnit string) (float64, string, error) {
+	unit, known := normalizeNutrientUnit(rawUnit)
+	if !known {
+		return 0, IssueUnknownUnit, fmt.Errorf("unknown unit %q", rawUnit)
+	}
+
+	stem := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(key, "_100g"), "_serving"), NutrimentsPrepared)
+	switch {
+	case stem == "energy-kcal" && unit == "kcal", (stem == "energy-kj" || stem == "energy") && unit == "kJ":
+		return value, "", nil
+	case stem == "energy-kcal" && unit == "kJ":
+		return value / KJ_PER_KCAL, "", nil
+	case (stem == "energy-kj" || stem == "energy") && unit == "kcal":
+		return value * KJ_PER_KCAL, "", nil
+	case stem == "energy-kcal" || stem == "energy-kj" || stem == "energy":
+		// Energy in a mass or percentage unit
+	case unit == "%" || unit == "% DV":
+		// A plain percentage of another nutrient is a daily value, as in nutrientSourceUnit
+		return 0, IssueUnconvertibleUnit, fmt.Errorf("cannot convert %% DV for %s", stem)
+	case stem == "alcohol" && (unit == "% vol" || unit == "%"):
+		return value, "", nil
+	case nutrientMassUnits[unit] != 0:
+		return value * nutrientMassUnits[unit], "", nil
+	case unit == "IU":
+		for _, definition := range NutrientRegistry {
+			if definition.Key == stem && definition.IUToMicrograms != 0 {
+				return value * definition.IUToMicrograms * 1e-6, "", nil
+			}
+		}
+	}
+	return 0, IssueUnconvertibleUnit, fmt.Errorf("cannot convert %s for %s", unit, stem)
+}
+
+// nutrimentModifierKey returns the _modifier key of a nutriments key, e.g. "sugars_modifier" for "sugars_100g"
+func nutrimentModifierKey(key string) string {
+	for _, suffix := range []string{"_100g", "_serving", "_value"} {
+		if strings.HasSuffix(key, suffix) {
+			return strings.TrimSuffix(key, suffix) + "_modifier"
+		}
+	}
+	return key + "_modifier"
+}
+
+// nutrimentQualifier returns the qualifier of a nutriments key without suffix, e.g. "sugars" or "fat_prepared"
+func nutrimentQualifier(nutriments map[string]interface{}, key string) string {
+	modifier, _ := nutriments[key+"_modifier"].(string)
+	return modifierQualifier(modifier)
+}
+
+// nutrimentValue returns the numeric value of a nutriments key when it is present and valid
+func nutrimentValue(nutriments map[string]interface{}, key string) (float64, bool) {
+	value, ok := nutriments[key]
+	if !ok {
+		return 0, false
+	}
+	fval, err := toFloat64(value, Locale{})
+	if err != nil {
+		return 0, false
+	}
+	return fval, true
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractNutrientQualifiers(t *testing.T) {
+	nutriments, _ := normalizeNutriments(map[string]interface{}{
+		"sugars_100g":     0.5,
+		"sugars_modifier": "<",
+		"fat_100g":        "~12",
+		"salt_100g":       "traces",
+		"proteins_100g":   8.0,
+	}, newLocale("en", ""))
+
+	reference := newReferenceServing("100g", DefaultFoodDensity)
+	extractNutrients(&reference, nutriments, NutrimentsAsSold, "_100g")
+	completeNutrients(&reference)
+
+	expected := map[string]string{
+		"sugar":         QualifierLessThan,
+		NutrientFat:     QualifierApproximately,
+		NutrientSalt:    QualifierTrace,
+		NutrientSodium:  QualifierTrace,
+		NutrientProtein: QualifierExact,
+	}
+	for field, qualifier := range expected {
+		if reference.Qualifier(field) != qualifier {
+			t.Errorf("%s: got %s, want %s", field, reference.Qualifier(field), qualifier)
+		}
+	}
+
+	// Scaled servings keep the qualifiers of the per-100g values
+	serving := ServingSize{WeightInGrams: 30, Nutrients: map[string]float64{}}
+	deriveServingNutrients(&serving, reference)
+	if serving.Qualifier("sugar") != QualifierLessThan || serving.Nutrient("sugar") != 0.15 {
+		t.Errorf("30 g serving: got sugar %g %s, want 0.15 %s", serving.Nutrient("sugar"), serving.Qualifier("sugar"), QualifierLessThan)
+	}
+}
+
+func TestResolveEnergy(t *testing.T) {
+	tests := []struct {
+		name       string
+		nutriments map[string]interface{}
+		variant    string
+		want       float64
+		source     string
+	}{
+		{"kcal preferred", map[string]interface{}{"energy-kcal_100g": 250.0, "energy-kj_100g": 1046.0, "energy_100g": 1046.0}, NutrimentsAsSold, 250, EnergySourceKcal},
+		{"kJ converted", map[string]interface{}{"energy-kj_100g": 418.4, "energy_100g": 418.4}, NutrimentsAsSold, 100, EnergySourceKj},
+		{"generic energy in kJ", map[string]interface{}{"energy_100g": 836.8}, NutrimentsAsSold, 200, EnergySourceEnergy},
+		{"generic energy converted from a kcal entry", map[string]interface{}{"energy_100g": 836.8, "energy_unit": "kcal", "energy_value": 200.0}, NutrimentsAsSold, 200, EnergySourceEnergy},
+		{"generic energy stored in kcal", map[string]interface{}{"energy_100g": 200.0, "energy_unit": "kcal", "energy_value": 200.0}, NutrimentsAsSold, 200, EnergySourceEnergy},
+		{"generic energy in kcal without value", map[string]interface{}{"energy_100g": 200.0, "energy_unit": " KCAL "}, NutrimentsAsSold, 200, EnergySourceEnergy},
+		{"generic energy with kJ unit", map[string]interface{}{"energy_100g": 836.8, "energy_unit": "kJ"}, NutrimentsAsSold, 200, EnergySourceEnergy},
+		{"prepared variant", map[string]interface{}{"energy-kcal_100g": 350.0, "energy-kcal_prepared_100g": 90.0}, NutrimentsPrepared, 90, EnergySourceKcal},
+		{"prepared energy unit", map[string]interface{}{"energy_prepared_100g": 90.0, "energy_prepared_unit": "kcal"}, NutrimentsPrepared, 90, EnergySourceEnergy},
+		{"no energy", map[string]interface{}{"proteins_100g": 10.0}, NutrimentsAsSold, 0, ""},
+	}
+	for _, tt := range tests {
+		got, source := resolveEnergy(tt.nutriments, tt.variant, "_100g")
+		if math.Abs(got-tt.want) > 1e-9 || source != tt.source {
+			t.Errorf("%s: got %g from %q, want %g from %q", tt.name, got, source, tt.want, tt.source)
+		}
+	}
+}
+
+func TestIsKcalEnergy(t *testing.T) {
+	tests := []struct {
+		name       string
+		nutriments map[string]interface{}
+		energy     float64
+		want       bool
+	}{
+		{"no unit", map[string]interface{}{}, 200, false},
+		{"kJ unit", map[string]interface{}{"energy_unit": "kJ"}, 200, false},
+		{"kcal unit", map[string]interface{}{"energy_unit": "kcal"}, 200, true},
+		{"kcal value kept as entered", map[string]interface{}{"energy_unit": "kcal", "energy_value": 200.0}, 200, true},
+		{"kcal value converted to kJ", map[string]interface{}{"energy_unit": "kcal", "energy_value": 200.0}, 836.8, false},
+	}
+	for _, tt := range tests {
+		if got := isKcalEnergy(tt.nutriments, NutrimentsAsSold, tt.energy); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// withNutrientRegistry restores NutrientRegistry after a test that changes it
+func withNutrientRegistry(t *testing.T) {
+	saved := append([]NutrientDefinition(nil), NutrientRegistry...)
+	t.Cleanup(func() { NutrientRegistry = saved })
+}
+
+func writeNutrientDefinitions(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "nutrients.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadNutrientRegistry(t *testing.T) {
+	withNutrientRegistry(t)
+	count := len(NutrientRegistry)
+
+	path := writeNutrientDefinitions(t, `[
+		{"field": "vitamin_c", "key": "vitamin-c", "unit": "µg"},
+		{"field": "taurine", "key": "taurine", "unit": "mg"},
+		{"field": "beta_carotene", "key": "beta-carotene", "unit": "mg", "factor": 500}
+	]`)
+	if err := loadNutrientRegistry(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(NutrientRegistry) != count+2 {
+		t.Fatalf("got %d definitions, want %d", len(NutrientRegistry), count+2)
+	}
+	for i, definition := range NutrientRegistry {
+		if definition.Field == "vitamin_c" && (definition.Unit != "µg" || definition.factor() != 1e6) {
+			t.Errorf("vitamin_c not overridden: %+v", definition)
+		}
+		if definition.Field == "vitamin_c" && NutrientRegistry[i-1].Field != "vitamin_a_iu" {
+			t.Errorf("overridden vitamin_c moved to position %d", i)
+		}
+	}
+	if appended := NutrientRegistry[count]; appended.Field != "taurine" || appended.factor() != 1000 {
+		t.Errorf("got appended definition %+v, want taurine in mg", appended)
+	}
+	if appended := NutrientRegistry[count+1]; appended.Field != "beta_carotene" || appended.factor() != 500 {
+		t.Errorf("got appended definition %+v, want beta_carotene with factor 500", appended)
+	}
+
+	// The explicit factor is applied to values OFF stores in grams
+	if value, ok := NutrientRegistry[count+1].convert(0.002, "g"); !ok || value != 1 {
+		t.Errorf("beta_carotene convert: got %g %v, want 1", value, ok)
+	}
+}
+
+func TestLoadNutrientRegistryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"malformed JSON", `[{"field": "taurine", "key": "taurine"`},
+		{"object instead of array", `{"field": "taurine", "key": "taurine"}`},
+		{"missing key", `[{"field": "taurine", "unit": "mg"}]`},
+		{"missing field", `[{"key": "taurine", "unit": "mg"}]`},
+	}
+	for _, tt := range tests {
+		withNutrientRegistry(t)
+		if err := loadNutrientRegistry(writeNutrientDefinitions(t, tt.content)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+
+	if err := loadNutrientRegistry(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file: no error")
+	}
+	if err := loadNutrientRegistry(""); err != nil {
+		t.Errorf("no path: got %v", err)
+	}
+}
+
+func TestServingSizeMarshalJSON(t *testing.T) {
+	withNutrientRegistry(t)
+	NutrientRegistry = append(NutrientRegistry, NutrientDefinition{Field: "taurine", Key: "taurine", Unit: "mg"})
+
+	ss := ServingSize{
+		MeasurementUnit: "g",
+		Quantity:        100,
+		WeightInGrams:   100,
+		Nutrients: map[string]float64{
+			"taurine":        400,
+			NutrientSalt:     0,
+			NutrientProtein:  8,
+			NutrientCalories: 250,
+			"unknown":        1,
+		},
+	}
+	data, err := json.Marshal(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+
+	// Nutrients follow the serving fields in registry order
+	order := []string{`"weight_in_grams":`, `"calories":250`, `"protein":8`, `"salt":0`, `"taurine":400`}
+	last := -1
+	for _, field := range order {
+		index := strings.Index(text, field)
+		if index < 0 {
+			t.Fatalf("%s missing in %s", field, text)
+		}
+		if index < last {
+			t.Errorf("%s out of order in %s", field, text)
+		}
+		last = index
+	}
+	if strings.Contains(text, `"unknown"`) || strings.Contains(text, `"fat"`) {
+		t.Errorf("unknown or missing nutrients written: %s", text)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("invalid JSON %s: %v", text, err)
+	}
+
+	// The legacy shape omits zeros
+	config.LegacyOmitEmpty = true
+	defer func() { config.LegacyOmitEmpty = false }()
+	data, _ = json.Marshal(ss)
+	if strings.Contains(string(data), `"salt"`) {
+		t.Errorf("legacy omitempty: zero salt written: %s", data)
+	}
+}
+
+func TestServingSizeMarshalJSONUnescaped(t *testing.T) {
+	withNutrientRegistry(t)
+
+	ss := ServingSize{MeasurementUnit: "Cookies & cream", Quantity: 1, WeightInGrams: 30, Nutrients: map[string]float64{NutrientProtein: 2}}
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(ss); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), `"Cookies & cream"`) {
+		t.Errorf("HTML characters escaped: %s", buffer.String())
+	}
+}
+
+func TestDeriveServingNutrients(t *testing.T) {
+	withNutrientRegistry(t)
+
+	per100g := ServingSize{
+		MeasurementUnit: "g",
+		Quantity:        100,
+		WeightInGrams:   100,
+		EnergySource:    EnergySourceKcal,
+		Nutrients:       map[string]float64{NutrientCalories: 400, NutrientProtein: 10, NutrientFat: 20, NutrientAlcohol: 5},
+	}
+	per100g.setQualifier(NutrientFat, QualifierLessThan)
+
+	// OFF gave the protein per serving, everything else is scaled to the 30 g serving
+	serving := ServingSize{MeasurementUnit: "Bar", Quantity: 1, WeightInGrams: 30, Nutrients: map[string]float64{NutrientProtein: 3}}
+	issues := deriveServingNutrients(&serving, per100g)
+	if len(issues) != 0 {
+		t.Errorf("got issues %+v", issues)
+	}
+	want := map[string]float64{NutrientCalories: 120, NutrientProtein: 3, NutrientFat: 6, NutrientAlcohol: 5}
+	for field, value := range want {
+		if math.Abs(serving.Nutrients[field]-value) > 1e-9 {
+			t.Errorf("%s: got %g, want %g", field, serving.Nutrients[field], value)
+		}
+	}
+	if !reflect.DeepEqual(serving.DerivedNutrients, []string{NutrientCalories, NutrientFat, NutrientAlcohol}) {
+		t.Errorf("derived nutrients: got %v", serving.DerivedNutrients)
+	}
+	if serving.Qualifier(NutrientFat) != QualifierLessThan || serving.EnergySource != EnergySourceKcal {
+		t.Errorf("got fat qualifier %q and energy source %q", serving.Qualifier(NutrientFat), serving.EnergySource)
+	}
+
+	// An OFF per-serving value far from the scaled one is kept and reported
+	serving = ServingSize{MeasurementUnit: "Bar", Quantity: 1, WeightInGrams: 30, Nutrients: map[string]float64{NutrientProtein: 9, NutrientCalories: 125}}
+	issues = deriveServingNutrients(&serving, per100g)
+	if len(issues) != 1 || issues[0].Code != IssueServingMismatch || issues[0].Nutrient != NutrientProtein || issues[0].Serving != "1 Bar" {
+		t.Fatalf("got issues %+v, want a protein serving_mismatch", issues)
+	}
+	if serving.Nutrients[NutrientProtein] != 9 {
+		t.Errorf("protein: got %g, want the OFF value 9", serving.Nutrients[NutrientProtein])
+	}
+
+	// Without a serving weight nothing can be scaled
+	serving = ServingSize{MeasurementUnit: "Bar", Quantity: 1, Nutrients: map[string]float64{}}
+	if issues := deriveServingNutrients(&serving, per100g); len(issues) != 0 || len(serving.Nutrients) != 0 {
+		t.Errorf("got nutrients %v and issues %+v without a weight", serving.Nutrients, issues)
+	}
+}
+
+func TestNutrientSourceUnitConvert(t *testing.T) {
+	definitions := make(map[string]NutrientDefinition)
+	for _, definition := range NutrientRegistry {
+		definitions[definition.Field] = definition
+	}
+
+	tests := []struct {
+		name       string
+		field      string
+		nutriments map[string]interface{} // Keys without the nutrient key, e.g. "_100g"
+		unit       string                 // Source unit of the _100g value, empty when rejected
+		converted  float64                // Value in the unit of the definition
+		ok         bool                   // Whether the source unit converts
+	}{
+		{"mg converted by OFF", "vitamin_c", map[string]interface{}{"_100g": 0.06, "_unit": "mg", "_value": 60.0}, "g", 60, true},
+		{"mg stored as entered", "vitamin_c", map[string]interface{}{"_100g": 60.0, "_unit": "mg", "_value": 60.0}, "mg", 60, true},
+		{"µg into mg", "vitamin_c", map[string]interface{}{"_100g": 500.0, "_unit": "µg", "_value": 500.0}, "µg", 0.5, true},
+		{"mcg converted by OFF", "vitamin_k", map[string]interface{}{"_100g": 0.00002, "_unit": "mcg", "_value": 20.0}, "g", 20, true},
+		{"IU with IUToMicrograms", "vitamin_d", map[string]interface{}{"_100g": 400.0, "_unit": "IU", "_value": 400.0}, "IU", 10, true},
+		{"IU without IUToMicrograms", "vitamin_c", map[string]interface{}{"_100g": 400.0, "_unit": "IU", "_value": 400.0}, "IU", 0, false},
+		{"selenium already in µg", "selenium", map[string]interface{}{"_100g": 30.0, "_unit": "µg", "_value": 30.0}, "µg", 30, true},
+		{"conflicting unit", "selenium", map[string]interface{}{"_100g": 0.00003, "_unit": "mg", "_value": 0.03}, "g", 30, true},
+		{"energy unit on a mass", "protein", map[string]interface{}{"_100g": 10.0, "_unit": "kcal", "_value": 10.0}, "kcal", 0, false},
+		{"unknown unit", "protein", map[string]interface{}{"_100g": 10.0, "_unit": "cups", "_value": 10.0}, "", 0, false},
+		{"% DV rejected", "vitamin_c", map[string]interface{}{"_100g": 15.0, "_unit": "% DV", "_value": 15.0}, "", 0, false},
+		{"% is a daily value", "calcium", map[string]interface{}{"_100g": 20.0, "_unit": "%", "_value": 20.0}, "", 0, false},
+		{"% converted by OFF", "calcium", map[string]interface{}{"_100g": 0.2, "_unit": "%", "_value": 20.0}, "g", 200, true},
+		{"% is the alcohol content", "alcohol", map[string]interface{}{"_100g": 5.0, "_unit": "%", "_value": 5.0}, "% vol", 5, true},
+		{"no unit", "protein", map[string]interface{}{"_100g": 10.0}, "g", 10, true},
+	}
+	for _, tt := range tests {
+		definition := definitions[tt.field]
+		nutriments := make(map[string]interface{})
+		for suffix, value := range tt.nutriments {
+			nutriments[definition.Key+suffix] = value
+		}
+		value := nutriments[definition.Key+"_100g"].(float64)
+
+		unit, err := nutrientSourceUnit(nutriments, definition, definition.Key, value)
+		if tt.unit == "" {
+			if err == nil {
+				t.Errorf("%s: got unit %q, want an error", tt.name, unit)
+			}
+			continue
+		}
+		if err != nil || unit != tt.unit {
+			t.Errorf("%s: got unit %q (%v), want %q", tt.name, unit, err, tt.unit)
+			continue
+		}
+		converted, ok := definition.convert(value, unit)
+		if ok != tt.ok || math.Abs(converted-tt.converted) > 1e-9 {
+			t.Errorf("%s: converted to %g %v, want %g %v", tt.name, converted, ok, tt.converted, tt.ok)
+		}
+	}
+}
+
+func TestDeriveSodiumSalt(t *testing.T) {
+	// Sodium in mg gives salt in g
+	ss := ServingSize{Nutrients: map[string]float64{NutrientSodium: 400}}
+	ss.setQualifier(NutrientSodium, QualifierLessThan)
+	deriveSodiumSalt(&ss)
+	if math.Abs(ss.Nutrients[NutrientSalt]-1) > 1e-9 || ss.Qualifier(NutrientSalt) != QualifierLessThan {
+		t.Errorf("salt from sodium: got %g %s, want 1 less_than", ss.Nutrients[NutrientSalt], ss.Qualifier(NutrientSalt))
+	}
+	if !reflect.DeepEqual(ss.DerivedNutrients, []string{NutrientSalt}) {
+		t.Errorf("salt from sodium: derived nutrients %v", ss.DerivedNutrients)
+	}
+
+	// Salt in g gives sodium in mg
+	ss = ServingSize{Nutrients: map[string]float64{NutrientSalt: 2.5}}
+	deriveSodiumSalt(&ss)
+	if math.Abs(ss.Nutrients[NutrientSodium]-1000) > 1e-9 || !reflect.DeepEqual(ss.DerivedNutrients, []string{NutrientSodium}) {
+		t.Errorf("sodium from salt: got %g, derived nutrients %v", ss.Nutrients[NutrientSodium], ss.DerivedNutrients)
+	}
+
+	// Values given by OFF are kept as they are, even when they disagree
+	ss = ServingSize{Nutrients: map[string]float64{NutrientSodium: 400, NutrientSalt: 3}}
+	deriveSodiumSalt(&ss)
+	if ss.Nutrients[NutrientSalt] != 3 || ss.Nutrients[NutrientSodium] != 400 || len(ss.DerivedNutrients) != 0 {
+		t.Errorf("both given: got %v, derived nutrients %v", ss.Nutrients, ss.DerivedNutrients)
+	}
+
+	// Neither is made up
+	ss = ServingSize{Nutrients: map[string]float64{NutrientProtein: 3}}
+	deriveSodiumSalt(&ss)
+	if hasNutrient(ss, NutrientSodium) || hasNutrient(ss, NutrientSalt) {
+		t.Errorf("none given: got %v", ss.Nutrients)
+	}
+}
+
+func TestEstimateCaloriesAlcohol(t *testing.T) {
+	// 330 ml of 5% beer holds 16.5 ml, 13.0185 g, of ethanol at 7 kcal per gram
+	beer := ServingSize{WeightInGrams: 330, Nutrients: map[string]float64{NutrientAlcohol: 5, NutrientCarbs: 10}}
+	want := 10*4 + 330*0.05*ALCOHOL_DENSITY*7
+	if got := estimateCalories(beer); math.Abs(got-want) > 1e-9 {
+		t.Errorf("got %g kcal, want %g", got, want)
+	}
+
+	// The estimate is used only without an energy value
+	estimateEnergy(&beer)
+	if math.Abs(beer.Nutrients[NutrientCalories]-want) > 1e-9 || beer.EnergySource != EnergySourceEstimate {
+		t.Errorf("got %g kcal from %q, want %g from the estimate", beer.Nutrients[NutrientCalories], beer.EnergySource, want)
+	}
+	spirit := ServingSize{WeightInGrams: 100, EnergySource: EnergySourceKcal, Nutrients: map[string]float64{NutrientAlcohol: 40, NutrientCalories: 231}}
+	estimateEnergy(&spirit)
+	if spirit.Nutrients[NutrientCalories] != 231 {
+		t.Errorf("declared energy replaced by %g kcal", spirit.Nutrients[NutrientCalories])
+	}
+}
+
+func TestProcessProductPreparedServings(t *testing.T) {
+	product := OpenFoodFactsProduct{
+		ID:                       "1",
+		Code:                     "1",
+		ProductName:              "Soup powder",
+		ServingSize:              "2 tbsp (30 g)",
+		NutritionDataPer:         "100g",
+		NutritionDataPreparedPer: "100g",
+		Nutriments: map[string]interface{}{
+			"proteins_100g":                10.0,
+			"proteins_serving":             3.0,
+			"proteins_prepared_100g":       2.0,
+			"proteins_prepared_serving":    5.0,
+			"energy-kcal_prepared_100g":    40.0,
+			"energy-kcal_prepared_serving": 100.0,
+		},
+	}
+	item, err := ProcessProduct(product)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type serving struct {
+		unit     string
+		weight   float64
+		prepared bool
+		protein  float64
+		calories float64
+	}
+	want := []serving{
+		{"tbsp", 30, false, 3, 12},
+		{"g", 100, false, 10, 40},
+		{"tbsp", 30, true, 5, 100},
+		{"g", 100, true, 2, 40},
+	}
+	got := []serving{}
+	for _, ss := range item.ServingSizes {
+		got = append(got, serving{ss.MeasurementUnit, ss.WeightInGrams, ss.Prepared, ss.Nutrient(NutrientProtein), ss.Nutrient(NutrientCalories)})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got servings %+v, want %+v", got, want)
+	}
+
+	// Without prepared values only the product as sold is emitted
+	for key := range product.Nutriments {
+		if strings.Contains(key, NutrimentsPrepared) {
+			delete(product.Nutriments, key)
+		}
+	}
+	item, err = ProcessProduct(product)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ss := range item.ServingSizes {
+		if ss.Prepared {
+			t.Errorf("prepared serving %s emitted without prepared values", servingLabel(ss))
+		}
+	}
+	if len(item.ServingSizes) != 2 {
+		t.Errorf("got %d servings, want 2", len(item.ServingSizes))
+	}
+}
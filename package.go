@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+)
+
+// Measurement units of the package serving sizes
+const PACKAGE_MEASUREMENT_UNIT = "Package"
+const PACKAGE_UNIT_MEASUREMENT_UNIT = "Unit"
+
+// PackageQuantity is the amount of product in the package, decoded from OFF quantity and product_quantity
+type PackageQuantity struct {
+	Units             float64 // Number of units of a multipack such as "6 x 330 ml", 1 otherwise
+	UnitWeightInGrams float64
+	WeightInGrams     float64
+	WeightSource      string
+}
+
+// parsePackageQuantity parses OFF quantity, e.g. "500 g" or "6 x 330 ml", with the serving size grammar.
+// product_quantity, the total OFF computed in product_quantity_unit, is used when quantity cannot be parsed.
+func parsePackageQuantity(product OpenFoodFactsProduct, food FoodDensity) (PackageQuantity, bool) {
+	locale := localeForProduct(product)
+	if units, amount, ok := parseMultipack(product.Quantity, locale); ok {
+		unitWeight := convertToGrams(amount.Quantity, amount.unitKey(), food)
+		if unitWeight > 0 {
+			return PackageQuantity{
+				Units:             units,
+				UnitWeightInGrams: unitWeight,
+				WeightInGrams:     units * unitWeight,
+				WeightSource:      weightSourceForUnit(amount.unitKey()),
+			}, true
+		}
+	}
+
+	if product.Quantity != "" {
+		parsed := parseServingSize(product.Quantity, locale, food)
+		if weight, ok := servingWeightAmount(parsed.Amounts); ok && parsed.Confidence >= config.MinServingConfidence {
+			weightInGrams := convertToGrams(weight.Quantity, weight.unitKey(), food)
+			if weightInGrams > 0 {
+				return PackageQuantity{Units: 1, UnitWeightInGrams: weightInGrams, WeightInGrams: weightInGrams, WeightSource: weightSourceForUnit(weight.unitKey())}, true
+			}
+		}
+	}
+
+	if product.ProductQuantity != nil {
+		productQuantity, err := parseOFFComputedNumber(product.ProductQuantity)
+		unit := strings.TrimSpace(product.ProductQuantityUnit)
+		if unit == "" {
+			unit = "g"
+		}
+		if err == nil && productQuantity > 0 {
+			weightInGrams := convertToGrams(productQuantity, unit, food)
+			if weightInGrams > 0 {
+				return PackageQuantity{Units: 1, UnitWeightInGrams: weightInGrams, WeightInGrams: weightInGrams, WeightSource: weightSourceForUnit(unit)}, true
+			}
+		}
+	}
+
+	return PackageQuantity{}, false
+}
+
+// parseMultipack recognizes "count x amount" and "amount x count", e.g. "6 x 330 ml" or "125 g x 4"
+func parseMultipack(quantity string, locale Locale) (units float64, amount ServingAmount, ok bool) {
+	lang := locale.Lang
+	tokens := tokenizeServing(normalizeServingString(quantity), locale)
+
+	isTimes := func(token servingToken) bool {
+		return token.Kind == servingTokenWord && (strings.EqualFold(token.Text, "x") || token.Text == "×")
+	}
+	isWeightUnit := func(token servingToken) bool {
+		if token.Kind != servingTokenWord {
+			return false
+		}
+		kind := newServingAmount(1, token.Text, lang).Kind
+		return kind == AmountKindMass || kind == AmountKindVolume
+	}
+
+	for i := 0; i+3 < len(tokens); i++ {
+		first, second, third, fourth := tokens[i], tokens[i+1], tokens[i+2], tokens[i+3]
+		if first.Kind == servingTokenNumber && isTimes(second) && third.Kind == servingTokenNumber && isWeightUnit(fourth) {
+			return first.Value, newServingAmount(third.Value, fourth.Text, lang), first.Value > 1
+		}
+		if first.Kind == servingTokenNumber && isWeightUnit(second) && isTimes(third) && fourth.Kind == servingTokenNumber {
+			return fourth.Value, newServingAmount(first.Value, second.Text, lang), fourth.Value > 1
+		}
+	}
+
+	return 0, ServingAmount{}, false
+}
+
+// packageServingSizes returns the whole-package serving and, for multipacks, the per-unit serving.
+// Nutrients are scaled from the reference serving. Weights that are already a serving size are skipped.
+func packageServingSizes(pkg PackageQuantity, reference ServingSize, existing []ServingSize) ([]ServingSize, []QualityIssue) {
+	servingSizes := []ServingSize{}
+	issues := []QualityIssue{}
+
+	hasWeight := func(weightInGrams float64) bool {
+		for _, ss := range existing {
+			if ss.WeightInGrams == weightInGrams {
+				return true
+			}
+		}
+		for _, ss := range servingSizes {
+			if ss.WeightInGrams == weightInGrams {
+				return true
+			}
+		}
+		return false
+	}
+
+	add := func(measurementUnit string, unitID string, weightInGrams float64) {
+		if weightInGrams <= 0 || hasWeight(weightInGrams) {
+			return
+		}
+		ss := ServingSize{
+			MeasurementUnit: measurementUnit,
+			Type:            3,
+			Quantity:        1,
+			WeightInGrams:   weightInGrams,
+			WeightSource:    pkg.WeightSource,
+			Nutrients:       map[string]float64{},
+		}
+		ss.setUnitID(unitID)
+		issues = append(issues, deriveServingNutrients(&ss, reference)...)
+		completeNutrients(&ss)
+		servingSizes = append(servingSizes, ss)
+	}
+
+	if pkg.Units > 1 {
+		add(PACKAGE_UNIT_MEASUREMENT_UNIT, UnitPiece, pkg.UnitWeightInGrams)
+	}
+	add(PACKAGE_MEASUREMENT_UNIT, UnitPackage, pkg.WeightInGrams)
+
+	return servingSizes, issues
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParsePackageQuantity(t *testing.T) {
+	tests := []struct {
+		product           OpenFoodFactsProduct
+		units             float64
+		unitWeightInGrams float64
+		weightInGrams     float64
+	}{
+		{OpenFoodFactsProduct{Quantity: "500 g"}, 1, 500, 500},
+		{OpenFoodFactsProduct{Quantity: "1 kg"}, 1, 1000, 1000},
+		{OpenFoodFactsProduct{Quantity: "6 x 330 ml"}, 6, 330, 1980},
+		{OpenFoodFactsProduct{Quantity: "125 g x 4"}, 4, 125, 500},
+		{OpenFoodFactsProduct{Quantity: "1,5 l"}, 1, 1500, 1500},
+		{OpenFoodFactsProduct{Quantity: "Pack", ProductQuantity: "250"}, 1, 250, 250},
+		{OpenFoodFactsProduct{ProductQuantity: 750.0, ProductQuantityUnit: "ml"}, 1, 750, 750},
+		{OpenFoodFactsProduct{Lang: "de", Quantity: "Pack", ProductQuantity: "1.250", ProductQuantityUnit: "kg"}, 1, 1250, 1250},
+	}
+
+	for _, test := range tests {
+		pkg, ok := parsePackageQuantity(test.product, DefaultFoodDensity)
+		if !ok || pkg.Units != test.units || pkg.UnitWeightInGrams != test.unitWeightInGrams || pkg.WeightInGrams != test.weightInGrams {
+			t.Errorf("%q/%v: got %+v, want %g x %g g = %g g", test.product.Quantity, test.product.ProductQuantity, pkg, test.units, test.unitWeightInGrams, test.weightInGrams)
+		}
+	}
+
+	if _, ok := parsePackageQuantity(OpenFoodFactsProduct{Quantity: "Pack"}, DefaultFoodDensity); ok {
+		t.Errorf("\"Pack\" without product_quantity: got a package quantity")
+	}
+}
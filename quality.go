@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// QualityIssue is a data-quality problem found while converting a product
+type QualityIssue struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Serving  string `json:"serving,omitempty"`
+	Nutrient string `json:"nutrient,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+// Quality issue severities. Products with errors are dropped or quarantined when configured.
+const (
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Quality issue codes
+const (
+	IssueServingMismatch       = "serving_mismatch"
+	IssueUnknownUnit           = "unknown_unit"
+	IssueUnconvertibleUnit     = "unconvertible_unit"
+	IssueNegativeValue         = "negative_value"
+	IssueNutrientExceedsWeight = "nutrient_exceeds_weight"
+	IssueMacrosExceedWeight    = "macros_exceed_weight"
+	IssueSugarExceedsCarbs     = "sugar_exceeds_carbs"
+	IssueSaturatedExceedsFat   = "saturated_fat_exceeds_fat"
+	IssueEnergyExceedsMaximum  = "energy_exceeds_maximum"
+	IssueEnergyMismatch        = "energy_mismatch"
+	IssueUnparsedServingSize   = "unparsed_serving_size"
+
+	// serving_quantity, the serving weight computed by OFF, replaced an unparsed serving size or disagrees with it
+	IssueServingQuantityUsed     = "serving_quantity_used"
+	IssueServingQuantityMismatch = "serving_quantity_mismatch"
+)
+
+// What to do with products that have quality errors
+const (
+	InvalidProductsKeep       = "keep"
+	InvalidProductsDrop       = "drop"
+	InvalidProductsQuarantine = "quarantine"
+)
+
+// Plausibility limits per 100 g
+const MAX_MACROS_PER_100G = 105.0
+const MAX_KCAL_PER_100G = 900.0
+
+// Tolerances for comparisons between nutrients, in grams per 100 g and kcal per 100 g
+const NUTRIENT_COMPARISON_TOLERANCE = 0.5
+const ENERGY_ABSOLUTE_TOLERANCE = 20.0
+const ENERGY_RELATIVE_TOLERANCE = 0.3
+
+// Calories more than this factor away from the macro estimate are an error instead of a warning
+const ENERGY_ERROR_RATIO = 2.0
+
+// qualityReportEntry is a line of the quality report
+type qualityReportEntry struct {
+	OffID   string         `json:"off_id"`
+	Barcode string         `json:"barcode"`
+	Issues  []QualityIssue `json:"issues"`
+}
+
+// QualityReport writes the issues of every product to a JSONL file
+type QualityReport struct {
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func NewQualityReport(path string) (*QualityReport, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetEscapeHTML(false)
+	return &QualityReport{file: file, encoder: encoder}, nil
+}
+
+// Write records the issues of a product, products without issues are skipped
+func (r *QualityReport) Write(item *FoodItem) error {
+	if len(item.Issues) == 0 {
+		return nil
+	}
+	return r.encoder.Encode(qualityReportEntry{OffID: item.OffID, Barcode: item.Barcode, Issues: item.Issues})
+}
+
+func (r *QualityReport) Close() error {
+	return r.file.Close()
+}
+
+// validateServing checks the physical bounds of the nutrients of a serving. Limits are scaled to the serving weight.
+func validateServing(ss ServingSize) []QualityIssue {
+	issues := []QualityIssue{}
+	if ss.WeightInGrams <= 0 {
+		return issues
+	}
+	label := servingLabel(ss)
+	scale := ss.WeightInGrams / 100
+
+	// Prepared servings are weighed as sold, only the prepared 100 g or 100 ml reference is weighed once prepared
+	weighed := !ss.Prepared || isReferenceServing(ss)
+
+	issue := func(code string, severity string, nutrient string, detail string, args ...interface{}) {
+		issues = append(issues, QualityIssue{Code: code, Severity: severity, Serving: label, Nutrient: nutrient, Detail: fmt.Sprintf(detail, args...)})
+	}
+
+	for _, definition := range NutrientRegistry {
+		value, ok := ss.Nutrients[definition.Field]
+		if !ok {
+			continue
+		}
+		if value < 0 {
+			issue(IssueNegativeValue, SeverityError, definition.Field, "%g %s", value, definition.Unit)
+			continue
+		}
+		unit, _ := normalizeNutrientUnit(definition.Unit)
+		if grams, isMass := nutrientMassUnits[unit]; weighed && isMass && value*grams > ss.WeightInGrams+NUTRIENT_COMPARISON_TOLERANCE*scale {
+			issue(IssueNutrientExceedsWeight, SeverityError, definition.Field, "%g %s in %g g", value, definition.Unit, ss.WeightInGrams)
+		}
+	}
+
+	protein := ss.Nutrient(NutrientProtein)
+	fat := ss.Nutrient(NutrientFat)
+	carbs := ss.Nutrient(NutrientCarbs)
+
+	if macros := protein + fat + carbs; weighed && macros > MAX_MACROS_PER_100G*scale {
+		issue(IssueMacrosExceedWeight, SeverityError, "", "protein, fat and carbs add up to %g g in %g g", macros, ss.WeightInGrams)
+	}
+
+	if sugar, ok := ss.Nutrients["sugar"]; ok && hasNutrient(ss, NutrientCarbs) && sugar > carbs+NUTRIENT_COMPARISON_TOLERANCE*scale {
+		issue(IssueSugarExceedsCarbs, SeverityError, "sugar", "%g g sugar with %g g carbs", sugar, carbs)
+	}
+
+	if saturated, ok := ss.Nutrients["saturated_fat"]; ok && hasNutrient(ss, NutrientFat) && saturated > fat+NUTRIENT_COMPARISON_TOLERANCE*scale {
+		issue(IssueSaturatedExceedsFat, SeverityError, "saturated_fat", "%g g saturated fat with %g g fat", saturated, fat)
+	}
+
+	calories, hasCalories := ss.Nutrients[NutrientCalories]
+	if hasCalories && weighed && calories > MAX_KCAL_PER_100G*scale {
+		issue(IssueEnergyExceedsMaximum, SeverityError, NutrientCalories, "%g kcal in %g g", calories, ss.WeightInGrams)
+	}
+
+	// The estimate is only comparable when every macro is known
+	hasMacros := hasNutrient(ss, NutrientProtein) && hasNutrient(ss, NutrientFat) && hasNutrient(ss, NutrientCarbs)
+	if hasCalories && ss.EnergySource != EnergySourceEstimate && hasMacros {
+		estimate := estimateCalories(ss)
+		difference := math.Abs(calories - estimate)
+		if difference > ENERGY_ABSOLUTE_TOLERANCE*scale && difference > ENERGY_RELATIVE_TOLERANCE*math.Max(calories, estimate) {
+			severity := SeverityWarning
+			if math.Max(calories, estimate) > ENERGY_ERROR_RATIO*math.Min(calories, estimate) {
+				severity = SeverityError
+			}
+			issue(IssueEnergyMismatch, severity, NutrientCalories, "%g kcal declared, %g kcal estimated from macros", calories, estimate)
+		}
+	}
+
+	return issues
+}
+
+// isReferenceServing reports whether the serving is the 100 g or 100 ml serving the OFF values refer to
+func isReferenceServing(ss ServingSize) bool {
+	return ss.Quantity == 100 && (ss.UnitID == UnitGram || ss.UnitID == UnitMilliliter)
+}
+
+func hasNutrient(ss ServingSize, field string) bool {
+	_, ok := ss.Nutrients[field]
+	return ok
+}
+
+// qualityFlags returns the distinct issue codes in sorted order
+func qualityFlags(issues []QualityIssue) []string {
+	seen := make(map[string]bool)
+	flags := []string{}
+	for _, issue := range issues {
+		if !seen[issue.Code] {
+			seen[issue.Code] = true
+			flags = append(flags, issue.Code)
+		}
+	}
+	sort.Strings(flags)
+	return flags
+}
+
+// HasErrors reports whether any quality issue of the product is an error
+func (item *FoodItem) HasErrors() bool {
+	for _, issue := range item.Issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// servingLabel describes a serving in quality issues, e.g. "2 Slices" or "100 g prepared"
+func servingLabel(ss ServingSize) string {
+	if ss.Prepared {
+		return fmt.Sprintf("%g %s prepared", ss.Quantity, ss.MeasurementUnit)
+	}
+	return fmt.Sprintf("%g %s", ss.Quantity, ss.MeasurementUnit)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateServing(t *testing.T) {
+	grams := func(weight float64, nutrients map[string]float64) ServingSize {
+		ss := ServingSize{MeasurementUnit: "g", Quantity: weight, WeightInGrams: weight, Nutrients: nutrients}
+		ss.setUnitID(UnitGram)
+		return ss
+	}
+	prepared := func(ss ServingSize) ServingSize {
+		ss.Prepared = true
+		return ss
+	}
+	slices := ServingSize{MeasurementUnit: "Slices", Quantity: 2, WeightInGrams: 50, Prepared: true, Nutrients: map[string]float64{NutrientProtein: 60}}
+	slices.setUnitID(UnitSlice)
+	estimated := grams(100, map[string]float64{NutrientCalories: 500, NutrientProtein: 10, NutrientFat: 5, NutrientCarbs: 20})
+	estimated.EnergySource = EnergySourceEstimate
+
+	tests := []struct {
+		name   string
+		ss     ServingSize
+		issues []string // Code:severity of every issue
+	}{
+		{"plausible", grams(100, map[string]float64{NutrientCalories: 250, NutrientProtein: 10, NutrientFat: 10, NutrientCarbs: 30, "sugar": 5, "saturated_fat": 2}), nil},
+		{"no weight", ServingSize{Nutrients: map[string]float64{NutrientProtein: -1}}, nil},
+		{"negative value", grams(100, map[string]float64{NutrientFat: -1}), []string{"negative_value:error"}},
+		{"nutrient exceeds weight", grams(30, map[string]float64{NutrientSodium: 40000}), []string{"nutrient_exceeds_weight:error"}},
+		{"macros exceed weight", grams(100, map[string]float64{NutrientProtein: 50, NutrientFat: 40, NutrientCarbs: 30}), []string{"macros_exceed_weight:error"}},
+		{"macros within tolerance", grams(100, map[string]float64{NutrientProtein: 50, NutrientFat: 30, NutrientCarbs: 24}), nil},
+		{"sugar exceeds carbs", grams(100, map[string]float64{NutrientCarbs: 10, "sugar": 12}), []string{"sugar_exceeds_carbs:error"}},
+		{"sugar without carbs", grams(100, map[string]float64{"sugar": 12}), nil},
+		{"saturated fat exceeds fat", grams(100, map[string]float64{NutrientFat: 5, "saturated_fat": 6}), []string{"saturated_fat_exceeds_fat:error"}},
+		{"energy exceeds maximum", grams(100, map[string]float64{NutrientCalories: 950}), []string{"energy_exceeds_maximum:error"}},
+		{"energy mismatch warning", grams(100, map[string]float64{NutrientCalories: 450, NutrientProtein: 10, NutrientFat: 10, NutrientCarbs: 40}), []string{"energy_mismatch:warning"}},
+		{"energy mismatch error", grams(100, map[string]float64{NutrientCalories: 500, NutrientProtein: 10, NutrientFat: 5, NutrientCarbs: 20}), []string{"energy_mismatch:error"}},
+		{"energy within tolerance", grams(100, map[string]float64{NutrientCalories: 250, NutrientProtein: 10, NutrientFat: 10, NutrientCarbs: 35}), nil},
+		{"energy without every macro", grams(100, map[string]float64{NutrientCalories: 500, NutrientProtein: 10}), nil},
+		{"prepared reference is weighed", prepared(grams(100, map[string]float64{NutrientProtein: 60, NutrientFat: 60})), []string{"macros_exceed_weight:error"}},
+		{"prepared serving is weighed as sold", slices, nil},
+		{"estimated energy is not compared", estimated, nil},
+	}
+
+	for _, tt := range tests {
+		got := []string{}
+		for _, issue := range validateServing(tt.ss) {
+			got = append(got, issue.Code+":"+issue.Severity)
+		}
+		if len(got) != len(tt.issues) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.issues)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.issues[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.issues)
+				break
+			}
+		}
+	}
+}
+
+func TestIsReferenceServing(t *testing.T) {
+	reference := newReferenceServing("100g", DefaultFoodDensity)
+	volume := newReferenceServing("100ml", DefaultFoodDensity)
+	grams := ServingSize{MeasurementUnit: "Grams", Quantity: 100}
+	grams.setUnitID(UnitGram)
+	cookies := ServingSize{MeasurementUnit: "g", Quantity: 100}
+	cookies.setUnitID(UnitPiece)
+
+	tests := []struct {
+		name string
+		ss   ServingSize
+		want bool
+	}{
+		{"100 g", reference, true},
+		{"100 ml", volume, true},
+		{"spelled out unit", grams, true},
+		{"other unit", cookies, false},
+	}
+	for _, tt := range tests {
+		if got := isReferenceServing(tt.ss); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
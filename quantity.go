@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Representative values of a quantity range such as "2-3 cookies", set with --serving-range
+const (
+	ServingRangeLow  = "low"
+	ServingRangeMid  = "mid"
+	ServingRangeHigh = "high"
+)
+
+// VulgarFractions are the Unicode fractions found in serving sizes, e.g. "½ cup" or "1¼ cups"
+var VulgarFractions = map[rune]float64{
+	'½': 1.0 / 2, '⅓': 1.0 / 3, '⅔': 2.0 / 3, '¼': 1.0 / 4, '¾': 3.0 / 4,
+	'⅕': 1.0 / 5, '⅖': 2.0 / 5, '⅗': 3.0 / 5, '⅘': 4.0 / 5, '⅙': 1.0 / 6, '⅚': 5.0 / 6,
+	'⅛': 1.0 / 8, '⅜': 3.0 / 8, '⅝': 5.0 / 8, '⅞': 7.0 / 8,
+}
+
+// ServingNumberWords maps the number words of each language to their value, e.g. "half a cup" or "une tranche".
+// English is used for every product, the words of the product lang are checked first.
+var ServingNumberWords = map[string]map[string]float64{
+	"en": {
+		"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+		"eleven": 11, "twelve": 12, "dozen": 12, "half": 0.5, "quarter": 0.25,
+	},
+	"fr": {
+		"un": 1, "une": 1, "deux": 2, "trois": 3, "quatre": 4, "cinq": 5, "six": 6, "sept": 7, "huit": 8, "neuf": 9, "dix": 10,
+		"douze": 12, "demi": 0.5, "demie": 0.5, "quart": 0.25,
+	},
+	"de": {
+		"ein": 1, "eine": 1, "einen": 1, "zwei": 2, "drei": 3, "vier": 4, "fünf": 5, "sechs": 6, "sieben": 7, "acht": 8, "neun": 9, "zehn": 10,
+		"zwölf": 12, "halb": 0.5, "halbe": 0.5, "halben": 0.5, "viertel": 0.25,
+	},
+	"es": {
+		"un": 1, "uno": 1, "una": 1, "dos": 2, "tres": 3, "cuatro": 4, "cinco": 5, "seis": 6, "siete": 7, "ocho": 8, "nueve": 9, "diez": 10,
+		"doce": 12, "medio": 0.5, "media": 0.5, "cuarto": 0.25,
+	},
+	"it": {
+		"un": 1, "uno": 1, "una": 1, "due": 2, "tre": 3, "quattro": 4, "cinque": 5, "sei": 6, "sette": 7, "otto": 8, "nove": 9, "dieci": 10,
+		"dodici": 12, "mezzo": 0.5, "mezza": 0.5, "quarto": 0.25,
+	},
+}
+
+// Words between two numbers of a range, e.g. "2 to 3", and between a number and its fraction, e.g. "one and a half"
+var servingRangeWords = map[string]bool{"to": true, "à": true, "bis": true, "a": true}
+var servingConjunctions = map[string]bool{"and": true, "et": true, "und": true, "y": true, "e": true}
+
+// Articles skipped after a number word, e.g. "half a cup"
+var servingArticles = map[string]bool{"a": true, "an": true}
+
+// isServingNumberStart reports whether a number, e.g. "30", ".5" or "½", starts at runes[i]
+func isServingNumberStart(runes []rune, i int) bool {
+	r := runes[i]
+	if unicode.IsDigit(r) || VulgarFractions[r] > 0 {
+		return true
+	}
+	return isDigitSeparator(r) && i+1 < len(runes) && unicode.IsDigit(runes[i+1])
+}
+
+// isDigitSeparator reports whether r can separate the digits of a number, as decimal or thousands separator
+func isDigitSeparator(r rune) bool {
+	return r == '.' || r == ','
+}
+
+// readServingNumber reads the number starting at runes[i] and returns its token and the index after it.
+// Decimals, simple fractions ("1/2" or "1 / 2"), Unicode fractions ("½") and numbers followed by one ("1½") are
+// read as one number. Numbers with a space before their fraction, e.g. "1 1/2", are joined by parseServingPhrase.
+func readServingNumber(runes []rune, i int, locale Locale) (servingToken, int) {
+	if value, ok := VulgarFractions[runes[i]]; ok {
+		return servingToken{Kind: servingTokenNumber, Text: string(runes[i]), Value: value, Fraction: true}, i + 1
+	}
+
+	start := i
+	for i < len(runes) && (unicode.IsDigit(runes[i]) || (isDigitSeparator(runes[i]) && i+1 < len(runes) && unicode.IsDigit(runes[i+1]))) {
+		i++
+	}
+	text := string(runes[start:i])
+	value, _ := parseLocaleNumber(text, locale)
+	whole := !strings.ContainsAny(text, ".,")
+
+	// A whole number followed by a Unicode fraction, e.g. "1½"
+	if i < len(runes) && VulgarFractions[runes[i]] > 0 && whole {
+		value += VulgarFractions[runes[i]]
+		i++
+		return servingToken{Kind: servingTokenNumber, Text: string(runes[start:i]), Value: value}, i
+	}
+
+	// A fraction such as "1/2", "1 / 2" or "1⁄2"
+	fraction := false
+	if j := skipSpaces(runes, i); j < len(runes) && (runes[j] == '/' || runes[j] == '⁄') && whole {
+		k := skipSpaces(runes, j+1)
+		end := k
+		for end < len(runes) && unicode.IsDigit(runes[end]) {
+			end++
+		}
+		if end > k {
+			denominator, _ := strconv.ParseFloat(string(runes[k:end]), 64)
+			if denominator != 0 {
+				text = string(runes[start:end])
+				value = value / denominator
+				fraction = true
+				i = end
+			}
+		}
+	}
+
+	return servingToken{Kind: servingTokenNumber, Text: text, Value: value, Fraction: fraction}, i
+}
+
+// isRangeDash reports whether a rune joins the two numbers of a range, e.g. "2-3"
+func isRangeDash(r rune) bool {
+	return r == '-' || r == '–' || r == '—'
+}
+
+// newServingRangeToken returns a number token for the range from low to high, valued with servingRangeValue
+func newServingRangeToken(low servingToken, high servingToken) servingToken {
+	return servingToken{
+		Kind:  servingTokenNumber,
+		Text:  low.Text + "-" + high.Text,
+		Value: servingRangeValue(low.Value, high.Value),
+		Min:   low.Value,
+		Max:   high.Value,
+	}
+}
+
+// servingRangeValue returns the representative value of a range for config.ServingRangeValue, the middle by default
+func servingRangeValue(low float64, high float64) float64 {
+	switch config.ServingRangeValue {
+	case ServingRangeLow:
+		return low
+	case ServingRangeHigh:
+		return high
+	default:
+		return math.Round((low+high)/2*1000) / 1000
+	}
+}
+
+// servingNumberWord returns the value of a number word in the product language or English
+func servingNumberWord(word string, lang string) (float64, bool) {
+	lowerWord := strings.ToLower(word)
+	for _, vocabularyLang := range []string{strings.ToLower(lang), "en"} {
+		if value, ok := ServingNumberWords[vocabularyLang][lowerWord]; ok {
+			return value, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,689 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// ServingAmount is a quantity with its unit recognized in a serving size string, e.g. "2 slices" or "57 g"
+type ServingAmount struct {
+	Quantity    float64 `json:"quantity"`
+	QuantityMin float64 `json:"quantity_min,omitempty"` // Bounds of a range such as "2-3 cookies", Quantity is its representative value
+	QuantityMax float64 `json:"quantity_max,omitempty"`
+	Unit        string  `json:"unit"`              // As written, e.g. "tranches"
+	UnitID      string  `json:"unit_id,omitempty"` // Canonical unit, e.g. "slice"
+	Kind        string  `json:"kind"`
+}
+
+// Kinds of serving amount units
+const (
+	AmountKindMass      = "mass"      // g, kg, oz, ...
+	AmountKindVolume    = "volume"    // ml, l, fl oz, ...
+	AmountKindHousehold = "household" // cup, tbsp, tsp
+	AmountKindCount     = "count"     // slices, cookies, bottles, ...
+	AmountKindNone      = "none"      // a number without unit
+)
+
+// ParsedServing is the result of parsing an OFF serving_size string
+type ParsedServing struct {
+	Input         string           `json:"input"`
+	Lang          string           `json:"lang,omitempty"`
+	Quantity      float64          `json:"quantity"`
+	Unit          string           `json:"unit"`
+	UnitID        string           `json:"unit_id,omitempty"`
+	Descriptor    string           `json:"descriptor,omitempty"`   // Words that are neither unit nor quantity, e.g. "Serving" in "Serving 30 g"
+	Amounts       []ServingAmount  `json:"amounts,omitempty"`      // Every recognized amount in order, including parenthesized equivalents
+	Alternatives  []ServingMeasure `json:"alternatives,omitempty"` // Further measures of compound strings, e.g. "2 tbsp" in "1 cup (240 ml) / 2 tbsp"
+	Qualifiers    []string         `json:"qualifiers,omitempty"`   // e.g. "about", "approx"
+	Unrecognized  []string         `json:"unrecognized,omitempty"` // Text the grammar could not place
+	WeightInGrams float64          `json:"weight_in_grams"`
+	WeightSource  string           `json:"weight_source,omitempty"`
+	Type          int              `json:"type"`
+	Rule          string           `json:"rule"`
+	Confidence    float64          `json:"confidence"`
+}
+
+// ServingMeasure is a household or count measure of the serving with its weight
+type ServingMeasure struct {
+	Quantity      float64 `json:"quantity"`
+	Unit          string  `json:"unit"`
+	UnitID        string  `json:"unit_id,omitempty"`
+	WeightInGrams float64 `json:"weight_in_grams"`
+	WeightSource  string  `json:"weight_source,omitempty"`
+	Type          int     `json:"type"`
+	Rule          string  `json:"rule"`
+}
+
+// Grammar rules a serving size can match
+const (
+	ServingRuleWeight            = "weight"              // "30 g", "8 fl oz"
+	ServingRuleMeasureWithWeight = "measure_with_weight" // "2 slices (57 g)", "1.5 g (1 tea bag)"
+	ServingRuleLabeledWeight     = "labeled_weight"      // "Serving 30 g"
+	ServingRuleHouseholdEstimate = "household_estimate"  // "1 cup", weight estimated from the food density
+	ServingRuleMeasure           = "measure"             // "1 bar", no weight
+	ServingRuleServingQuantity   = "serving_quantity"    // Weight taken from OFF serving_quantity
+	ServingRuleNone              = "none"
+)
+
+// Confidence of each rule before penalties
+var servingRuleConfidence = map[string]float64{
+	ServingRuleWeight:            1.0,
+	ServingRuleMeasureWithWeight: 0.95,
+	ServingRuleLabeledWeight:     0.85,
+	ServingRuleHouseholdEstimate: 0.7,
+	ServingRuleMeasure:           0.5,
+	ServingRuleServingQuantity:   0.9,
+	ServingRuleNone:              0,
+}
+
+// Confidence penalties
+const SERVING_UNRECOGNIZED_PENALTY = 0.5
+const SERVING_UNBALANCED_PENALTY = 0.1
+const SERVING_IMPLIED_QUANTITY_PENALTY = 0.1
+const SERVING_RANGE_PENALTY = 0.1
+
+// Parses below this confidence are not emitted as serving sizes
+const MIN_SERVING_CONFIDENCE = 0.5
+
+// Relative and absolute differences in grams tolerated between the parsed weight and OFF serving_quantity
+const SERVING_QUANTITY_RELATIVE_TOLERANCE = 0.05
+const SERVING_QUANTITY_ABSOLUTE_TOLERANCE = 0.5
+
+// ServingQualifiers are words that qualify an amount without changing it
+var ServingQualifiers = map[string]bool{
+	"~": true, "≈": true, "about": true, "approx": true, "approximately": true, "around": true, "ca": true, "circa": true, "env": true, "environ": true,
+}
+
+type servingTokenKind int
+
+const (
+	servingTokenNumber servingTokenKind = iota
+	servingTokenWord
+	servingTokenOpen
+	servingTokenClose
+	servingTokenSeparator
+	servingTokenOther
+)
+
+type servingToken struct {
+	Kind     servingTokenKind
+	Text     string
+	Value    float64
+	Min      float64 // Bounds of a range, 0 otherwise
+	Max      float64
+	Fraction bool // A fraction below 1, e.g. "1/2" or "½", which can follow a whole number
+}
+
+// servingPhrase is a run of tokens between parentheses and separators
+type servingPhrase struct {
+	Tokens        []servingToken
+	Parenthesized bool
+	Segment       int // Index of the part of a compound string, separated by "/", "=" or "or" outside parentheses
+}
+
+// parseServingSize parses an OFF serving_size string with a small grammar. Units are recognized in the vocabulary
+// of the product language and English. Volumes and household measures are converted to grams with the density of the food.
+func parseServingSize(servingSizeStr string, locale Locale, food FoodDensity) ParsedServing {
+	lang := locale.Lang
+	parsed := ParsedServing{Input: servingSizeStr, Lang: lang, Quantity: 1, Type: 3, Rule: ServingRuleNone}
+
+	phrases, unbalanced, unrecognized := splitServingPhrases(tokenizeServing(normalizeServingString(servingSizeStr), locale))
+	parsed.Unrecognized = unrecognized
+
+	labels := []string{}
+	descriptors := []string{}
+	impliedQuantity := false
+	segments := [][]ServingAmount{}
+	for i, phrase := range phrases {
+		amounts, label, descriptor, qualifiers, implied, unknown := parseServingPhrase(phrase, lang)
+		parsed.Amounts = append(parsed.Amounts, amounts...)
+		for len(segments) <= phrase.Segment {
+			segments = append(segments, []ServingAmount{})
+		}
+		segments[phrase.Segment] = append(segments[phrase.Segment], amounts...)
+		parsed.Qualifiers = append(parsed.Qualifiers, qualifiers...)
+		parsed.Unrecognized = append(parsed.Unrecognized, unknown...)
+		if label != "" {
+			labels = append(labels, label)
+		}
+		if descriptor != "" {
+			descriptors = append(descriptors, descriptor)
+		}
+		if i == 0 {
+			impliedQuantity = implied
+		}
+	}
+
+	// The measure shown to the user is the first household or count amount, the weight the first declared mass.
+	// Compound strings such as "1 bar (45 g) or 2 bars (90 g)" have further measures as alternatives.
+	weight, hasWeight := servingWeightAmount(parsed.Amounts)
+	measures := servingMeasures(segments, weight, hasWeight, food)
+
+	switch {
+	case len(measures) > 0:
+		measure := measures[0]
+		parsed.Rule = measure.Rule
+		parsed.Quantity, parsed.Unit, parsed.UnitID = measure.Quantity, measure.Unit, measure.UnitID
+		parsed.WeightInGrams, parsed.WeightSource = measure.WeightInGrams, measure.WeightSource
+		parsed.Type = measure.Type
+		parsed.Alternatives = measures[1:]
+	case hasWeight && len(labels) > 0:
+		parsed.Rule = ServingRuleLabeledWeight
+		parsed.Quantity, parsed.Unit = 1, labels[0]
+		parsed.UnitID, _ = servingUnitID(labels[0], lang)
+		labels = labels[1:]
+		parsed.WeightInGrams = convertToGrams(weight.Quantity, weight.unitKey(), food)
+		parsed.WeightSource = weightSourceForUnit(weight.unitKey())
+	case hasWeight:
+		parsed.Rule = ServingRuleWeight
+		parsed.Quantity, parsed.Unit, parsed.UnitID = weight.Quantity, weight.Unit, weight.UnitID
+		parsed.WeightInGrams = convertToGrams(weight.Quantity, weight.unitKey(), food)
+		parsed.WeightSource = weightSourceForUnit(weight.unitKey())
+		parsed.Type = servingTypeForUnit(weight.unitKey())
+
+		// Plain grams or ml are a single serving of that weight
+		if isServingWeightUnit(weight.unitKey()) {
+			parsed.Quantity, parsed.Unit, parsed.UnitID = 1, "Serving", UnitServing
+			parsed.Type = 3
+		}
+	}
+
+	parsed.Descriptor = strings.Join(append(labels, descriptors...), " ")
+
+	confidence := servingRuleConfidence[parsed.Rule]
+	if len(parsed.Unrecognized) > 0 {
+		confidence -= SERVING_UNRECOGNIZED_PENALTY
+	}
+	if unbalanced {
+		confidence -= SERVING_UNBALANCED_PENALTY
+	}
+	if impliedQuantity && parsed.Rule != ServingRuleLabeledWeight {
+		confidence -= SERVING_IMPLIED_QUANTITY_PENALTY
+	}
+	for _, amount := range parsed.Amounts {
+		if amount.QuantityMax > 0 {
+			confidence -= SERVING_RANGE_PENALTY
+			break
+		}
+	}
+	parsed.Confidence = math.Max(0, math.Round(confidence*100)/100)
+
+	return parsed
+}
+
+// normalizeServingString fixes common phrases before tokenizing, misspelled units such as "ONZ" are in the vocabulary
+func normalizeServingString(servingSizeStr string) string {
+	servingSizeStr = strings.TrimSpace(servingSizeStr)
+	servingSizeStr = strings.Trim(servingSizeStr, "|")
+	servingSizeStr = strings.ReplaceAll(servingSizeStr, "Amount per serving", "Serving")
+
+	return servingSizeStr
+}
+
+// tokenizeServing splits a serving size string into numbers, words, parentheses and separators.
+// Numbers and units written together ("30g") are split, fractions ("1/2", "½") and ranges ("2-3") become a single number.
+// Decimal and thousands separators are read as the locale writes them.
+func tokenizeServing(input string, locale Locale) []servingToken {
+	runes := []rune(input)
+	tokens := []servingToken{}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case isServingNumberStart(runes, i):
+			var token servingToken
+			token, i = readServingNumber(runes, i, locale)
+
+			// A range such as "2-3" or "1/2 – 1"
+			if j := skipSpaces(runes, i); j < len(runes) && isRangeDash(runes[j]) {
+				if k := skipSpaces(runes, j+1); k < len(runes) && isServingNumberStart(runes, k) {
+					if high, end := readServingNumber(runes, k, locale); high.Value > token.Value {
+						token, i = newServingRangeToken(token, high), end
+					}
+				}
+			}
+			tokens = append(tokens, token)
+		case unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || runes[i] == '.' || runes[i] == '\'' || (runes[i] == '-' && i+1 < len(runes) && unicode.IsLetter(runes[i+1]))) {
+				i++
+			}
+			word := strings.TrimRight(string(runes[start:i]), ".")
+			kind := servingTokenWord
+			if strings.EqualFold(word, "or") {
+				kind = servingTokenSeparator
+			}
+			tokens = append(tokens, servingToken{Kind: kind, Text: word})
+		case r == '(' || r == '[':
+			tokens = append(tokens, servingToken{Kind: servingTokenOpen, Text: string(r)})
+			i++
+		case r == ')' || r == ']':
+			tokens = append(tokens, servingToken{Kind: servingTokenClose, Text: string(r)})
+			i++
+		case r == '/' || r == '=' || r == ';' || r == '|':
+			tokens = append(tokens, servingToken{Kind: servingTokenSeparator, Text: string(r)})
+			i++
+		case r == ',':
+			// A comma outside a number only separates words, e.g. "2 slices, 50 g"
+			i++
+		case r == '~' || r == '≈' || r == '×':
+			tokens = append(tokens, servingToken{Kind: servingTokenWord, Text: string(r)})
+			i++
+		default:
+			tokens = append(tokens, servingToken{Kind: servingTokenOther, Text: string(r)})
+			i++
+		}
+	}
+
+	return tokens
+}
+
+func skipSpaces(runes []rune, i int) int {
+	for i < len(runes) && unicode.IsSpace(runes[i]) {
+		i++
+	}
+	return i
+}
+
+// splitServingPhrases groups the tokens into phrases at parentheses and separators
+func splitServingPhrases(tokens []servingToken) (phrases []servingPhrase, unbalanced bool, unrecognized []string) {
+	depth := 0
+	segment := 0
+	current := servingPhrase{}
+
+	flush := func() {
+		if len(current.Tokens) > 0 {
+			phrases = append(phrases, current)
+		}
+		current = servingPhrase{Parenthesized: depth > 0, Segment: segment}
+	}
+
+	for _, token := range tokens {
+		switch token.Kind {
+		case servingTokenOpen:
+			depth++
+			flush()
+		case servingTokenClose:
+			if depth == 0 {
+				unbalanced = true
+				continue
+			}
+			depth--
+			flush()
+		case servingTokenSeparator:
+			if depth == 0 {
+				segment++
+			}
+			flush()
+		case servingTokenOther:
+			unrecognized = append(unrecognized, token.Text)
+		default:
+			current.Tokens = append(current.Tokens, token)
+		}
+	}
+	flush()
+
+	return phrases, unbalanced || depth != 0, unrecognized
+}
+
+// parseServingPhrase reads "[label] number unit [descriptor] number unit ..." from a phrase.
+// A phrase without numbers is a single amount with an implied quantity of 1, e.g. "cup".
+func parseServingPhrase(phrase servingPhrase, lang string) (amounts []ServingAmount, label string, descriptor string, qualifiers []string, implied bool, unrecognized []string) {
+	words := []string{}
+	descriptors := []string{}
+	var quantity, quantityMin, quantityMax float64
+	hasQuantity := false
+	quantityWord := false // The quantity is a number word, e.g. "one"
+
+	finish := func() {
+		if hasQuantity {
+			unit, rest := splitServingUnit(words, lang)
+			amount := newServingAmount(quantity, unit, lang)
+			amount.QuantityMin, amount.QuantityMax = quantityMin, quantityMax
+			amounts = append(amounts, amount)
+			if unit == "" {
+				unrecognized = append(unrecognized, fmt.Sprintf("%g", quantity))
+			}
+			if rest != "" {
+				descriptors = append(descriptors, rest)
+			}
+		} else if len(words) > 0 {
+			label = strings.Join(words, " ")
+		}
+		words = words[:0]
+	}
+
+	for _, token := range phrase.Tokens {
+		numberWord := false
+		if token.Kind == servingTokenWord {
+			if value, ok := servingNumberWord(token.Text, lang); ok {
+				token = servingToken{Kind: servingTokenNumber, Text: token.Text, Value: value, Fraction: value < 1}
+				numberWord = true
+			}
+		}
+
+		// Words joining a number to its fraction or to the end of a range, e.g. "one and a half" or "2 to 3"
+		conjunction := len(words) == 1 && servingConjunctions[strings.ToLower(words[0])]
+		joined := len(words) == 0 || conjunction
+		rangeWord := len(words) == 1 && servingRangeWords[strings.ToLower(words[0])]
+
+		switch token.Kind {
+		case servingTokenNumber:
+			switch {
+			case hasQuantity && len(words) == 1 && (strings.EqualFold(words[0], "x") || words[0] == "×"):
+				// A multipack such as "2 x 15 g" is a single amount of the total
+				quantity *= token.Value
+				quantityMin, quantityMax = quantityMin*token.Value, quantityMax*token.Value
+			case hasQuantity && len(words) == 0 && numberWord && token.Fraction && quantityWord && quantity == 1:
+				// "One" before a fraction word is an article, e.g. "one half" or "eine halbe Tasse"
+				quantity = token.Value
+			case hasQuantity && joined && token.Fraction && quantityMax == 0 && quantity == math.Trunc(quantity):
+				// A mixed number such as "1 1/2", "1 ½" or "one and a half"
+				quantity += token.Value
+			case hasQuantity && rangeWord && quantityMax == 0 && token.Min == 0 && token.Value > quantity:
+				// A range written with a word, e.g. "2 to 3"
+				quantityMin, quantityMax = quantity, token.Value
+				quantity = servingRangeValue(quantityMin, quantityMax)
+			default:
+				finish()
+				quantity, quantityMin, quantityMax = token.Value, token.Min, token.Max
+				hasQuantity = true
+			}
+			quantityWord = numberWord
+			words = words[:0]
+		case servingTokenWord:
+			if ServingQualifiers[strings.ToLower(token.Text)] {
+				qualifiers = append(qualifiers, token.Text)
+				continue
+			}
+			// Articles after a number, e.g. "half a cup" or "one and a half"
+			if hasQuantity && joined && servingArticles[strings.ToLower(token.Text)] {
+				continue
+			}
+			words = append(words, token.Text)
+		}
+	}
+	finish()
+
+	// Without any number the words are the unit, e.g. "cup" or "(1 TEA BAG)" written as "(TEA BAG)"
+	if len(amounts) == 0 && label != "" {
+		unit, rest := splitServingUnit(strings.Fields(label), lang)
+		amounts = append(amounts, newServingAmount(1, unit, lang))
+		label = ""
+		implied = true
+		if rest != "" {
+			descriptors = append(descriptors, rest)
+		}
+	}
+
+	return amounts, label, strings.Join(descriptors, " "), qualifiers, implied, unrecognized
+}
+
+// splitServingUnit splits a known unit of up to three words off the front of the words, e.g. "g cereal" -> "g", "cereal"
+// or "cuillère à soupe" -> "cuillère à soupe". Unknown words are kept together as the unit, e.g. "TEA BAG".
+func splitServingUnit(words []string, lang string) (unit string, rest string) {
+	for n := 3; n >= 1; n-- {
+		if len(words) >= n {
+			candidate := strings.Join(words[:n], " ")
+			if _, ok := servingUnitID(candidate, lang); ok {
+				return candidate, strings.Join(words[n:], " ")
+			}
+			if kind := servingAmountKind(candidate); kind != AmountKindCount && kind != AmountKindNone {
+				return candidate, strings.Join(words[n:], " ")
+			}
+		}
+	}
+	return strings.Join(words, " "), ""
+}
+
+// newServingAmount classifies the unit of an amount by its canonical unit ID when the vocabulary knows it
+func newServingAmount(quantity float64, unit string, lang string) ServingAmount {
+	amount := ServingAmount{Quantity: quantity, Unit: unit}
+	amount.UnitID, _ = servingUnitID(unit, lang)
+	amount.Kind = servingAmountKind(amount.unitKey())
+	return amount
+}
+
+// unitKey returns the canonical unit ID, or the lowercase unit for units missing from the vocabulary
+func (a ServingAmount) unitKey() string {
+	if a.UnitID != "" {
+		return a.UnitID
+	}
+	return strings.ToLower(a.Unit)
+}
+
+// servingAmountKind classifies a unit as mass, volume, household measure or count
+func servingAmountKind(unit string) string {
+	lowerUnit := strings.ToLower(strings.TrimSpace(unit))
+	switch {
+	case lowerUnit == "":
+		return AmountKindNone
+	case isHouseholdMeasure(lowerUnit):
+		return AmountKindHousehold
+	case isVolumeUnit(lowerUnit):
+		return AmountKindVolume
+	case convertToGrams(1, lowerUnit, DefaultFoodDensity) > 0:
+		return AmountKindMass
+	default:
+		return AmountKindCount
+	}
+}
+
+// Measure returns the primary measure of the serving
+func (p ParsedServing) Measure() ServingMeasure {
+	return ServingMeasure{Quantity: p.Quantity, Unit: p.Unit, UnitID: p.UnitID, WeightInGrams: p.WeightInGrams, WeightSource: p.WeightSource, Type: p.Type, Rule: p.Rule}
+}
+
+// servingMeasures returns the first household or count measure of every segment of a compound string.
+// A measure takes the weight declared in its own segment, then the weight shared by the whole string, as every
+// measure is an equivalent of the same serving, and is estimated from its own unit otherwise. In strings with
+// household or count measures, segments with only an imperial weight, e.g. "1 oz" in "30 g = 1 oz = 2 biscuits",
+// are measures of their own after the others.
+func servingMeasures(segments [][]ServingAmount, shared ServingAmount, hasShared bool, food FoodDensity) []ServingMeasure {
+	hasCountMeasure := false
+	for _, amounts := range segments {
+		if _, ok := firstServingAmount(amounts, AmountKindHousehold, AmountKindCount); ok {
+			hasCountMeasure = true
+		}
+	}
+
+	// Segments without a measure, e.g. "28 g" in "1 slice 1 oz / 28 g", belong to the previous measure
+	grouped := [][]ServingAmount{}
+	imperial := [][]ServingAmount{}
+	pending := []ServingAmount{}
+	for _, amounts := range segments {
+		if _, ok := firstServingAmount(amounts, AmountKindHousehold, AmountKindCount); !ok {
+			if _, ok := imperialServingAmount(amounts); ok && hasCountMeasure {
+				imperial = append(imperial, amounts)
+			} else if len(grouped) > 0 {
+				grouped[len(grouped)-1] = append(grouped[len(grouped)-1], amounts...)
+			} else {
+				pending = append(pending, amounts...)
+			}
+			continue
+		}
+		grouped = append(grouped, append(pending, amounts...))
+		pending = nil
+	}
+
+	measures := []ServingMeasure{}
+	for _, amounts := range grouped {
+		amount, ok := firstServingAmount(amounts, AmountKindHousehold, AmountKindCount)
+		if !ok || hasServingMeasure(measures, amount) {
+			continue
+		}
+
+		measure := ServingMeasure{Quantity: amount.Quantity, Unit: amount.Unit, UnitID: amount.UnitID, Type: servingTypeForUnit(amount.unitKey())}
+		weight, hasWeight := servingWeightAmount(amounts)
+		if !hasWeight {
+			weight, hasWeight = shared, hasShared
+		}
+
+		if hasWeight {
+			measure.Rule = ServingRuleMeasureWithWeight
+			measure.WeightInGrams = convertToGrams(weight.Quantity, weight.unitKey(), food)
+			measure.WeightSource = weightSourceForUnit(weight.unitKey())
+		} else {
+			measure.Rule = ServingRuleMeasure
+			measure.WeightInGrams = convertToGrams(amount.Quantity, amount.unitKey(), food)
+			if measure.WeightInGrams == 0 {
+				measure.WeightInGrams = estimateWeightFromUnit(amount.Quantity, amount.unitKey(), food)
+			}
+			if measure.WeightInGrams > 0 {
+				measure.Rule = ServingRuleHouseholdEstimate
+				measure.WeightSource = WeightSourceEstimated
+			}
+		}
+
+		measures = append(measures, measure)
+	}
+
+	for _, amounts := range imperial {
+		amount, _ := imperialServingAmount(amounts)
+		if hasServingMeasure(measures, amount) {
+			continue
+		}
+		// The metric weight of the segment, e.g. "1 oz (28 g)", or the shared one is preferred over the conversion
+		weight, _ := servingWeightAmount(amounts)
+		if !isMetricUnit(weight.unitKey()) && hasShared {
+			weight = shared
+		}
+		measures = append(measures, ServingMeasure{
+			Quantity:      amount.Quantity,
+			Unit:          amount.Unit,
+			UnitID:        amount.UnitID,
+			Type:          servingTypeForUnit(amount.unitKey()),
+			WeightInGrams: convertToGrams(weight.Quantity, weight.unitKey(), food),
+			WeightSource:  weightSourceForUnit(weight.unitKey()),
+			Rule:          ServingRuleMeasureWithWeight,
+		})
+	}
+	return measures
+}
+
+// imperialServingAmount returns the first mass or volume in an imperial unit, e.g. "1 oz" or "8 fl oz"
+func imperialServingAmount(amounts []ServingAmount) (ServingAmount, bool) {
+	for _, amount := range amounts {
+		if (amount.Kind == AmountKindMass || amount.Kind == AmountKindVolume) && isImperialUnit(amount.unitKey()) {
+			return amount, true
+		}
+	}
+	return ServingAmount{}, false
+}
+
+func hasServingMeasure(measures []ServingMeasure, amount ServingAmount) bool {
+	for _, measure := range measures {
+		sameUnit := strings.EqualFold(measure.Unit, amount.Unit) || (measure.UnitID != "" && measure.UnitID == amount.UnitID)
+		if measure.Quantity == amount.Quantity && sameUnit {
+			return true
+		}
+	}
+	return false
+}
+
+// firstServingAmount returns the first amount of one of the kinds
+func firstServingAmount(amounts []ServingAmount, kinds ...string) (ServingAmount, bool) {
+	for _, amount := range amounts {
+		for _, kind := range kinds {
+			if amount.Kind == kind {
+				return amount, true
+			}
+		}
+	}
+	return ServingAmount{}, false
+}
+
+// servingWeightAmount returns the amount the weight is taken from: a metric mass, then any mass, then a volume
+func servingWeightAmount(amounts []ServingAmount) (ServingAmount, bool) {
+	for _, amount := range amounts {
+		if amount.Kind == AmountKindMass && isMetricUnit(amount.unitKey()) {
+			return amount, true
+		}
+	}
+	return firstServingAmount(amounts, AmountKindMass, AmountKindVolume)
+}
+
+// isServingWeightUnit reports whether amounts in the unit are emitted as a single serving of that weight
+func isServingWeightUnit(unit string) bool {
+	switch strings.ToLower(unit) {
+	case "g", "gr", "grm", "gram", "grams", "ml":
+		return true
+	default:
+		return false
+	}
+}
+
+func isHouseholdMeasure(unit string) bool {
+	switch strings.ToLower(unit) {
+	case "cup", "cups", "tbsp", "tablespoon", "tablespoons", "tsp", "teaspoon", "teaspoons":
+		return true
+	default:
+		return false
+	}
+}
+
+// servingTypeForUnit returns 1 for metric, 2 for imperial and 3 for other units
+func servingTypeForUnit(unit string) int {
+	lowerUnit := strings.ToLower(unit)
+	if isMetricUnit(lowerUnit) {
+		return 1
+	} else if isImperialUnit(lowerUnit) {
+		return 2
+	}
+	return 3
+}
+
+// applyServingQuantity checks the parsed serving against OFF serving_quantity, the weight OFF computed from
+// serving_size, in serving_quantity_unit. Parses without a weight take the OFF weight, estimated weights are
+// replaced by an OFF weight in grams, and other disagreements are reported.
+func applyServingQuantity(parsed *ParsedServing, quantity float64, unit string, food FoodDensity) []QualityIssue {
+	issues := []QualityIssue{}
+	unit = strings.ToLower(strings.TrimSpace(unit))
+	if unit == "" {
+		unit = "g"
+	}
+	weightInGrams := convertToGrams(quantity, unit, food)
+	if weightInGrams <= 0 {
+		return issues
+	}
+	weightSource := weightSourceForUnit(unit)
+
+	switch {
+	case parsed.Confidence < config.MinServingConfidence:
+		// The string could not be parsed, or is missing, and the OFF weight is a single serving
+		if parsed.Input != "" {
+			issues = append(issues, QualityIssue{
+				Code:     IssueServingQuantityUsed,
+				Severity: SeverityWarning,
+				Detail:   fmt.Sprintf("%q matched rule %s with confidence %g, using serving_quantity %g %s", parsed.Input, parsed.Rule, parsed.Confidence, quantity, unit),
+			})
+		}
+		parsed.Quantity, parsed.Unit, parsed.UnitID, parsed.Type = 1, "Serving", UnitServing, 3
+		parsed.Alternatives = nil
+		parsed.WeightInGrams, parsed.WeightSource = weightInGrams, weightSource
+		parsed.Rule, parsed.Confidence = ServingRuleServingQuantity, servingRuleConfidence[ServingRuleServingQuantity]
+	case parsed.WeightInGrams <= 0:
+		// A measure without weight, e.g. "1 bar"
+		parsed.WeightInGrams, parsed.WeightSource = weightInGrams, weightSource
+		parsed.Rule, parsed.Confidence = ServingRuleServingQuantity, servingRuleConfidence[ServingRuleServingQuantity]
+	default:
+		difference := math.Abs(parsed.WeightInGrams - weightInGrams)
+		if difference <= SERVING_QUANTITY_ABSOLUTE_TOLERANCE || difference <= SERVING_QUANTITY_RELATIVE_TOLERANCE*math.Max(parsed.WeightInGrams, weightInGrams) {
+			break
+		}
+		issues = append(issues, QualityIssue{
+			Code:     IssueServingQuantityMismatch,
+			Severity: SeverityWarning,
+			Detail:   fmt.Sprintf("%q parsed as %g g (%s), serving_quantity is %g %s", parsed.Input, parsed.WeightInGrams, parsed.WeightSource, quantity, unit),
+		})
+		if parsed.WeightSource == WeightSourceEstimated && weightSource == WeightSourceDeclared {
+			parsed.WeightInGrams, parsed.WeightSource = weightInGrams, weightSource
+		}
+	}
+
+	return issues
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files in testdata from the current parser output")
+
+const SERVING_CORPUS_FILE = "testdata/serving_sizes.txt"
+const SERVING_GOLDEN_FILE = "testdata/serving_sizes.golden.jsonl"
+
+func TestParseServingSize(t *testing.T) {
+	milk := FoodDensity{Category: "en:milks", Density: 1.03}
+	flour := FoodDensity{Category: "en:flours", Density: 0.53, Cup: 125, Tablespoon: 7.8, Teaspoon: 2.6}
+
+	tests := []struct {
+		input         string
+		food          FoodDensity
+		quantity      float64
+		unit          string
+		weightInGrams float64
+		servingType   int
+		rule          string
+	}{
+		{"30 g", DefaultFoodDensity, 1, "Serving", 30, 3, ServingRuleWeight},
+		{"30g", DefaultFoodDensity, 1, "Serving", 30, 3, ServingRuleWeight},
+		{"1,5 g", DefaultFoodDensity, 1, "Serving", 1.5, 3, ServingRuleWeight},
+		{"250 ml", milk, 1, "Serving", 257.5, 3, ServingRuleWeight},
+		{"1 kg", DefaultFoodDensity, 1, "kg", 1000, 1, ServingRuleWeight},
+		{"1 ONZ", DefaultFoodDensity, 1, "ONZ", 28.3495, 2, ServingRuleWeight},
+		{"2 SLICES (57 g)", DefaultFoodDensity, 2, "SLICES", 57, 3, ServingRuleMeasureWithWeight},
+		{"1.5 g (1 TEA BAG)", DefaultFoodDensity, 1, "TEA BAG", 1.5, 3, ServingRuleMeasureWithWeight},
+		{"1 slice 1 oz / 28 g", DefaultFoodDensity, 1, "slice", 28, 3, ServingRuleMeasureWithWeight},
+		{"3/4 cup (30 g)", DefaultFoodDensity, 0.75, "cup", 30, 3, ServingRuleMeasureWithWeight},
+		{"1 cup", flour, 1, "cup", 125, 3, ServingRuleHouseholdEstimate},
+		{"2 tbsp", flour, 2, "tbsp", 15.6, 3, ServingRuleHouseholdEstimate},
+		{"Serving 30g", DefaultFoodDensity, 1, "Serving", 30, 3, ServingRuleLabeledWeight},
+		{"2 x 15 g", DefaultFoodDensity, 1, "Serving", 30, 3, ServingRuleWeight},
+		{"1 bar", DefaultFoodDensity, 1, "bar", 0, 3, ServingRuleMeasure},
+	}
+
+	for _, test := range tests {
+		parsed := parseServingSize(test.input, newLocale("en", ""), test.food)
+		if parsed.Quantity != test.quantity || parsed.Unit != test.unit || parsed.Type != test.servingType || parsed.Rule != test.rule {
+			t.Errorf("%q: got %g %q type %d rule %s, want %g %q type %d rule %s", test.input, parsed.Quantity, parsed.Unit, parsed.Type, parsed.Rule, test.quantity, test.unit, test.servingType, test.rule)
+		}
+		if diff := parsed.WeightInGrams - test.weightInGrams; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%q: got %g g, want %g g", test.input, parsed.WeightInGrams, test.weightInGrams)
+		}
+	}
+}
+
+func TestParseServingSizeLocalized(t *testing.T) {
+	tests := []struct {
+		input         string
+		lang          string
+		unitID        string
+		weightInGrams float64
+	}{
+		{"1 tranche (25 g)", "fr", UnitSlice, 25},
+		{"1 cuillère à soupe (15 g)", "fr", UnitTablespoon, 15},
+		{"2 c. à café", "fr", UnitTeaspoon, 10},
+		{"2 EL", "de", UnitTablespoon, 30},
+		{"1 Scheibe (30 g)", "de", UnitSlice, 30},
+		{"1 porción (40 g)", "es", UnitPortion, 40},
+		{"1 cucchiaio", "it", UnitTablespoon, 15},
+		{"3 biscotti (30 g)", "it", UnitCookie, 30},
+		{"2 slices (50 g)", "fr", UnitSlice, 50},
+	}
+
+	for _, test := range tests {
+		parsed := parseServingSize(test.input, newLocale(test.lang, ""), DefaultFoodDensity)
+		if parsed.UnitID != test.unitID {
+			t.Errorf("%s %q: got unit %q, want %q", test.lang, test.input, parsed.UnitID, test.unitID)
+		}
+		if diff := parsed.WeightInGrams - test.weightInGrams; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%s %q: got %g g, want %g g", test.lang, test.input, parsed.WeightInGrams, test.weightInGrams)
+		}
+	}
+
+	// Words of another language are not units
+	if parsed := parseServingSize("2 EL", newLocale("fr", ""), DefaultFoodDensity); parsed.UnitID == UnitTablespoon {
+		t.Errorf("\"2 EL\" with lang fr: got unit %q", parsed.UnitID)
+	}
+}
+
+func TestParseServingSizeQuantities(t *testing.T) {
+	tests := []struct {
+		input    string
+		lang     string
+		quantity float64
+		min      float64
+		max      float64
+	}{
+		{"1 1/2 cups", "en", 1.5, 0, 0},
+		{"1⁄2 cup", "en", 0.5, 0, 0},
+		{"½ cup", "en", 0.5, 0, 0},
+		{"1½ cups", "en", 1.5, 0, 0},
+		{"1 ¾ cup (30 g)", "en", 1.75, 0, 0},
+		{"half a cup", "en", 0.5, 0, 0},
+		{"one and a half cups", "en", 1.5, 0, 0},
+		{"two slices (50 g)", "en", 2, 0, 0},
+		{"2-3 cookies", "en", 2.5, 2, 3},
+		{"2 – 3 cookies (30 g)", "en", 2.5, 2, 3},
+		{"2 to 3 slices", "en", 2.5, 2, 3},
+		{"1/2 - 1 cup", "en", 0.75, 0.5, 1},
+		{"une tranche (20 g)", "fr", 1, 0, 0},
+		{"eine halbe Tasse", "de", 0.5, 0, 0},
+		{"due biscotti", "it", 2, 0, 0},
+	}
+
+	for _, test := range tests {
+		parsed := parseServingSize(test.input, newLocale(test.lang, ""), DefaultFoodDensity)
+		if len(parsed.Amounts) == 0 {
+			t.Errorf("%q: no amounts", test.input)
+			continue
+		}
+		amount := parsed.Amounts[0]
+		if amount.Quantity != test.quantity || amount.QuantityMin != test.min || amount.QuantityMax != test.max || len(parsed.Unrecognized) > 0 {
+			t.Errorf("%q: got %g (%g-%g) unrecognized %v, want %g (%g-%g)", test.input, amount.Quantity, amount.QuantityMin, amount.QuantityMax, parsed.Unrecognized, test.quantity, test.min, test.max)
+		}
+	}
+
+	defer func(value string) { config.ServingRangeValue = value }(config.ServingRangeValue)
+	for value, quantity := range map[string]float64{ServingRangeLow: 2, ServingRangeMid: 2.5, ServingRangeHigh: 3} {
+		config.ServingRangeValue = value
+		if parsed := parseServingSize("2-3 cookies", newLocale("en", ""), DefaultFoodDensity); parsed.Quantity != quantity {
+			t.Errorf("--serving-range %s: got %g, want %g", value, parsed.Quantity, quantity)
+		}
+	}
+}
+
+func TestParseServingSizeCompound(t *testing.T) {
+	type measure struct {
+		quantity      float64
+		unitID        string
+		weightInGrams float64
+		rule          string
+	}
+	tests := []struct {
+		input    string
+		measures []measure // The parsed measure followed by its alternatives
+	}{
+		{"1 bar (45 g) or 2 bars (90 g)", []measure{{1, UnitBar, 45, ServingRuleMeasureWithWeight}, {2, UnitBar, 90, ServingRuleMeasureWithWeight}}},
+		{"1 cup (240 ml) / 2 tbsp", []measure{{1, UnitCup, 240, ServingRuleMeasureWithWeight}, {2, UnitTablespoon, 240, ServingRuleMeasureWithWeight}}},
+		{"1 tbsp (15 g) = 3 tsp", []measure{{1, UnitTablespoon, 15, ServingRuleMeasureWithWeight}, {3, UnitTeaspoon, 15, ServingRuleMeasureWithWeight}}},
+		{"3 cookies or 1 bar (30 g)", []measure{{3, UnitCookie, 30, ServingRuleMeasureWithWeight}, {1, UnitBar, 30, ServingRuleMeasureWithWeight}}},
+		{"30 g = 1 oz = 2 biscuits", []measure{{2, UnitCookie, 30, ServingRuleMeasureWithWeight}, {1, UnitOunce, 30, ServingRuleMeasureWithWeight}}},
+		{"2 biscuits (25 g) / 1 oz (28 g)", []measure{{2, UnitCookie, 25, ServingRuleMeasureWithWeight}, {1, UnitOunce, 28, ServingRuleMeasureWithWeight}}},
+		{"1 slice 1 oz / 28 g", []measure{{1, UnitSlice, 28, ServingRuleMeasureWithWeight}}},
+		{"1 oz = 28 g", nil},
+	}
+
+	for _, test := range tests {
+		parsed := parseServingSize(test.input, newLocale("en", ""), DefaultFoodDensity)
+		got := []measure{}
+		if parsed.Rule == ServingRuleMeasureWithWeight || parsed.Rule == ServingRuleHouseholdEstimate || parsed.Rule == ServingRuleMeasure {
+			got = append(got, measure{parsed.Quantity, parsed.UnitID, parsed.WeightInGrams, parsed.Rule})
+		}
+		for _, alternative := range parsed.Alternatives {
+			got = append(got, measure{alternative.Quantity, alternative.UnitID, alternative.WeightInGrams, alternative.Rule})
+		}
+		if len(got) != len(test.measures) {
+			t.Errorf("%q: got %+v, want %+v", test.input, got, test.measures)
+			continue
+		}
+		for i := range got {
+			if got[i].quantity != test.measures[i].quantity || got[i].unitID != test.measures[i].unitID || got[i].rule != test.measures[i].rule ||
+				math.Abs(got[i].weightInGrams-test.measures[i].weightInGrams) > 1e-9 {
+				t.Errorf("%q: got %+v, want %+v", test.input, got, test.measures)
+				break
+			}
+		}
+	}
+}
+
+func TestServingSizeUnits(t *testing.T) {
+	tests := []struct {
+		servingSize     string
+		lang            string
+		measurementUnit string
+		unitID          string
+		plural          string
+	}{
+		{"2 TBSP (30 g)", "en", "tbsp", UnitTablespoon, "tbsp"},
+		{"1 Tablespoon (15 g)", "en", "tbsp", UnitTablespoon, "tbsp"},
+		{"2 SLICES (57 g)", "en", "slices", UnitSlice, "slices"},
+		{"1 slice (28 g)", "en", "slice", UnitSlice, "slices"},
+		{"8 FL.OZ", "en", "fl oz", UnitFluidOunce, "fl oz"},
+		{"1 OZA", "en", "oz", UnitOunce, "oz"},
+		{"2 tranches (50 g)", "fr", "slices", UnitSlice, "slices"},
+		{"1 TEA BAG (1.5 g)", "en", "Tea bag", "", ""},
+	}
+
+	for _, test := range tests {
+		item, err := ProcessProduct(OpenFoodFactsProduct{ID: "1", Code: "1", Lang: test.lang, ServingSize: test.servingSize})
+		if err != nil {
+			t.Fatalf("%q: %v", test.servingSize, err)
+		}
+		ss := item.ServingSizes[0]
+		if ss.MeasurementUnit != test.measurementUnit || ss.UnitID != test.unitID || ss.UnitPlural != test.plural {
+			t.Errorf("%q: got %q %q plural %q, want %q %q plural %q", test.servingSize, ss.MeasurementUnit, ss.UnitID, ss.UnitPlural, test.measurementUnit, test.unitID, test.plural)
+		}
+	}
+}
+
+func TestApplyServingQuantity(t *testing.T) {
+	tests := []struct {
+		servingSize   string
+		quantity      float64
+		unit          string
+		weightInGrams float64
+		rule          string
+		issue         string
+	}{
+		{"30 g", 30, "g", 30, ServingRuleWeight, ""},
+		{"30 g", 30.2, "g", 30, ServingRuleWeight, ""},
+		{"2 slices (57 g)", 28, "g", 57, ServingRuleMeasureWithWeight, IssueServingQuantityMismatch},
+		{"1 cup", 200, "g", 200, ServingRuleHouseholdEstimate, IssueServingQuantityMismatch},
+		{"1 bar", 45, "g", 45, ServingRuleServingQuantity, ""},
+		{"about 3 pieces (approx", 40, "", 40, ServingRuleServingQuantity, IssueServingQuantityUsed},
+		{"1 bottle", 330, "ml", 330, ServingRuleServingQuantity, ""},
+		{"", 25, "g", 25, ServingRuleServingQuantity, ""},
+	}
+
+	for _, test := range tests {
+		parsed := parseServingSize(test.servingSize, newLocale("en", ""), DefaultFoodDensity)
+		issues := applyServingQuantity(&parsed, test.quantity, test.unit, DefaultFoodDensity)
+		if parsed.WeightInGrams != test.weightInGrams || parsed.Rule != test.rule {
+			t.Errorf("%q with %g %s: got %g g rule %s, want %g g rule %s", test.servingSize, test.quantity, test.unit, parsed.WeightInGrams, parsed.Rule, test.weightInGrams, test.rule)
+		}
+		issue := ""
+		if len(issues) > 0 {
+			issue = issues[0].Code
+		}
+		if issue != test.issue {
+			t.Errorf("%q with %g %s: got issue %q, want %q", test.servingSize, test.quantity, test.unit, issue, test.issue)
+		}
+	}
+}
+
+func TestProcessProductServingQuantityLocale(t *testing.T) {
+	// serving_quantity is written by OFF with a decimal point, also for products in decimal comma languages
+	item, err := ProcessProduct(OpenFoodFactsProduct{ID: "1", Code: "1", Lang: "de", ServingSize: "1 Riegel", ServingQuantity: "28.350", ServingQuantityUnit: "g"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	weights := []float64{}
+	for _, ss := range item.ServingSizes {
+		weights = append(weights, ss.WeightInGrams)
+	}
+	if len(weights) != 2 || weights[0] != 28.35 {
+		t.Errorf("got serving weights %v, want [28.35 100]", weights)
+	}
+}
+
+func TestParseServingSizeLowConfidence(t *testing.T) {
+	for _, input := range []string{"about 3 pieces (approx", "Serving", "%", "1 2", ""} {
+		if parsed := parseServingSize(input, newLocale("en", ""), DefaultFoodDensity); parsed.Confidence >= MIN_SERVING_CONFIDENCE {
+			t.Errorf("%q: got confidence %g with rule %s, want below %g", input, parsed.Confidence, parsed.Rule, MIN_SERVING_CONFIDENCE)
+		}
+	}
+}
+
+// TestParseServingSizeGolden compares the parser output for every corpus string with the golden file.
+// Run "go test -run TestParseServingSizeGolden -update" after changing parser rules and review the diff of testdata.
+func TestParseServingSizeGolden(t *testing.T) {
+	corpus, err := readServingCorpus(SERVING_CORPUS_FILE)
+	if err != nil {
+		t.Fatalf("Failed to read corpus: %v", err)
+	}
+
+	actual := make([]string, 0, len(corpus))
+	for _, entry := range corpus {
+		line, err := json.Marshal(parseServingSize(entry.input, newLocale(entry.lang, ""), DefaultFoodDensity))
+		if err != nil {
+			t.Fatalf("%q: %v", entry.input, err)
+		}
+		actual = append(actual, string(line))
+	}
+
+	if *updateGolden {
+		err := os.WriteFile(SERVING_GOLDEN_FILE, []byte(strings.Join(actual, "\n")+"\n"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(SERVING_GOLDEN_FILE)
+	if err != nil {
+		t.Fatalf("Failed to read golden file, run with -update to create it: %v", err)
+	}
+	golden := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var parsed ParsedServing
+		if err := json.Unmarshal([]byte(line), &parsed); err != nil {
+			t.Fatalf("Invalid golden line %s: %v", line, err)
+		}
+		golden[parsed.Lang+"\t"+parsed.Input] = line
+	}
+
+	for i, entry := range corpus {
+		expected, ok := golden[entry.lang+"\t"+entry.input]
+		if !ok {
+			t.Errorf("%s %q: missing from the golden file, run with -update", entry.lang, entry.input)
+		} else if expected != actual[i] {
+			t.Errorf("%s %q:\n got  %s\n want %s", entry.lang, entry.input, actual[i], expected)
+		}
+	}
+}
+
+// servingCorpusEntry is a corpus line, either "input" for English or "lang<TAB>input"
+type servingCorpusEntry struct {
+	lang  string
+	input string
+}
+
+// readServingCorpus returns the serving size strings of a corpus file, skipping comments
+func readServingCorpus(path string) ([]servingCorpusEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	corpus := []servingCorpusEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if lang, input, ok := strings.Cut(line, "\t"); ok {
+			corpus = append(corpus, servingCorpusEntry{lang: lang, input: input})
+		} else {
+			corpus = append(corpus, servingCorpusEntry{lang: "en", input: line})
+		}
+	}
+	return corpus, scanner.Err()
+}
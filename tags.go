@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+)
+
+// Tag is an Open Food Facts tag such as "en:tree-nuts" split into its language prefix and value
+type Tag struct {
+	Lang  string // Language prefix, empty when the tag has none (e.g. free text)
+	Value string // Value as written, without the prefix
+}
+
+// ParseTag splits a tag into language prefix and value. Only prefixes that look like
+// language codes are split off, so values containing colons are left intact.
+func ParseTag(s string) Tag {
+	s = strings.TrimSpace(s)
+	if prefix, value, found := strings.Cut(s, ":"); found {
+		lang := strings.ToLower(strings.TrimSpace(prefix))
+		if isTaxonomyLanguage(lang) {
+			return Tag{Lang: lang, Value: strings.TrimSpace(value)}
+		}
+	}
+	return Tag{Value: s}
+}
+
+// MapKey returns the value in the form used by AllergenMap keys, e.g. "tree-nuts" -> "TREE NUTS"
+func (t Tag) MapKey() string {
+	value := strings.NewReplacer("-", " ", "_", " ").Replace(t.Value)
+	return strings.ToUpper(strings.Join(strings.Fields(value), " "))
+}
+
+// String returns the canonical lowercase form of the tag, e.g. "fr:graines-de-sesame"
+func (t Tag) String() string {
+	if t.Lang == "" {
+		return normalizeTaxonomyName(t.Value)
+	}
+	return taxonomyKey(t.Lang, t.Value)
+}
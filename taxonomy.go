@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Taxonomy is a parsed Open Food Facts taxonomy file such as allergens.txt or ingredients.txt
+type Taxonomy struct {
+	Entries  map[string]*TaxonomyEntry
+	synonyms map[string]string
+}
+
+// TaxonomyEntry is a single block of a taxonomy file
+type TaxonomyEntry struct {
+	ID         string              // Canonical tag, e.g. "en:milk"
+	Parents    []string            // Canonical tags of the parent entries
+	Names      map[string][]string // Language code to synonyms as written in the file
+	Properties map[string]string   // e.g. "wikidata:en" or "allergens:en"
+}
+
+var unaccentReplacer = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "æ", "ae",
+	"ç", "c",
+	"è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i",
+	"ñ", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "œ", "oe",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u",
+	"ý", "y", "ÿ", "y",
+	"ß", "ss",
+)
+
+// LoadTaxonomy opens and parses a taxonomy file
+func LoadTaxonomy(path string) (*Taxonomy, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseTaxonomy(file)
+}
+
+// ParseTaxonomy parses the Open Food Facts taxonomy format. Entries are separated by blank lines,
+// parents are declared with "< lang:name", names with "lang: name1, name2" and properties with
+// "property:lang: value". Global "synonyms:" and "stopwords:" blocks are skipped.
+func ParseTaxonomy(r io.Reader) (*Taxonomy, error) {
+	taxonomy := &Taxonomy{
+		Entries:  make(map[string]*TaxonomyEntry),
+		synonyms: make(map[string]string),
+	}
+
+	var entry *TaxonomyEntry
+	var parents []string
+	var order []*TaxonomyEntry
+	rawParents := make(map[*TaxonomyEntry][]string)
+
+	flush := func() {
+		if entry != nil {
+			if _, exists := taxonomy.Entries[entry.ID]; !exists {
+				taxonomy.Entries[entry.ID] = entry
+				order = append(order, entry)
+			}
+			rawParents[entry] = parents
+		}
+		entry = nil
+		parents = nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+
+		if line == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "<") {
+			parents = append(parents, strings.TrimSpace(strings.TrimPrefix(line, "<")))
+			continue
+		}
+
+		prefix, rest, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+
+		if prefix == "synonyms" || prefix == "stopwords" {
+			continue
+		}
+
+		if !isTaxonomyLanguage(prefix) {
+			// Property line such as "wikidata:en: Q8495"
+			lang, value, found := strings.Cut(rest, ":")
+			if entry != nil && found {
+				entry.Properties[prefix+":"+strings.TrimSpace(lang)] = strings.TrimSpace(value)
+			}
+			continue
+		}
+
+		names := splitTaxonomyNames(rest)
+		if len(names) == 0 {
+			continue
+		}
+
+		if entry == nil {
+			entry = &TaxonomyEntry{
+				ID:         taxonomyKey(prefix, names[0]),
+				Names:      make(map[string][]string),
+				Properties: make(map[string]string),
+			}
+		}
+		entry.Names[prefix] = append(entry.Names[prefix], names...)
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, e := range order {
+		for lang, names := range e.Names {
+			for _, name := range names {
+				key := taxonomyKey(lang, name)
+				if _, exists := taxonomy.synonyms[key]; !exists {
+					taxonomy.synonyms[key] = e.ID
+				}
+			}
+		}
+	}
+
+	for _, e := range order {
+		for _, parent := range rawParents[e] {
+			if id, ok := taxonomy.Resolve(parent); ok {
+				e.Parents = append(e.Parents, id)
+			}
+		}
+	}
+
+	return taxonomy, nil
+}
+
+// Resolve returns the canonical entry ID for a tag or name in any language, e.g. "fr:lait" -> "en:milk"
+func (t *Taxonomy) Resolve(tag string) (string, bool) {
+	lang, value := splitTaxonomyTag(tag)
+	if id, ok := t.synonyms[taxonomyKey(lang, value)]; ok {
+		return id, true
+	}
+	// Language independent names such as E-numbers are declared with "xx:"
+	id, ok := t.synonyms[taxonomyKey("xx", value)]
+	return id, ok
+}
+
+// Ancestors returns every parent of the entry, nearest first
+func (t *Taxonomy) Ancestors(id string) []string {
+	ancestors := []string{}
+	seen := map[string]bool{id: true}
+	queue := []string{id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		entry, ok := t.Entries[current]
+		if !ok {
+			continue
+		}
+		for _, parent := range entry.Parents {
+			if !seen[parent] {
+				seen[parent] = true
+				ancestors = append(ancestors, parent)
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return ancestors
+}
+
+// BuildAllergenSynonyms maps every synonym of the allergen taxonomy, in every language, onto
+// the standardized values of AllergenMap. The ingredients taxonomy is optional and contributes
+// entries that either name an allergen directly or declare an "allergens:en" property.
+func BuildAllergenSynonyms(allergens *Taxonomy, ingredients *Taxonomy) map[string]string {
+	synonyms := make(map[string]string)
+
+	allergenIDs := make(map[string]string)
+	if allergens != nil {
+		for _, id := range sortedEntryIDs(allergens) {
+			entry := allergens.Entries[id]
+			if allergen, ok := mapTaxonomyEntry(entry); ok {
+				allergenIDs[id] = allergen
+				addEntrySynonyms(synonyms, entry, allergen)
+			}
+		}
+	}
+
+	if ingredients != nil {
+		for _, id := range sortedEntryIDs(ingredients) {
+			entry := ingredients.Entries[id]
+			allergen, ok := mapTaxonomyEntry(entry)
+			if !ok {
+				allergen, ok = resolveAllergenProperty(entry.Properties["allergens:en"], allergens, allergenIDs)
+			}
+			if ok {
+				addEntrySynonyms(synonyms, entry, allergen)
+			}
+		}
+	}
+
+	return synonyms
+}
+
+// mapTaxonomyEntry finds the AllergenMap value for an entry using its English names
+func mapTaxonomyEntry(entry *TaxonomyEntry) (string, bool) {
+	_, idValue := splitTaxonomyTag(entry.ID)
+	candidates := append([]string{idValue}, entry.Names["en"]...)
+	for _, candidate := range candidates {
+		key := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(candidate), "-", " "))
+		if allergen, ok := AllergenMap[key]; ok {
+			return allergen, true
+		}
+	}
+	return "", false
+}
+
+// resolveAllergenProperty maps an "allergens:en" property value such as "en:milk, en:gluten" to the first known allergen
+func resolveAllergenProperty(property string, allergens *Taxonomy, allergenIDs map[string]string) (string, bool) {
+	if property == "" {
+		return "", false
+	}
+	for _, tag := range strings.Split(property, ",") {
+		tag = strings.TrimSpace(tag)
+		if allergens != nil {
+			if id, ok := allergens.Resolve(tag); ok {
+				if allergen, ok := allergenIDs[id]; ok {
+					return allergen, true
+				}
+			}
+		}
+		_, value := splitTaxonomyTag(tag)
+		if allergen, ok := AllergenMap[strings.ToUpper(strings.ReplaceAll(value, "-", " "))]; ok {
+			return allergen, true
+		}
+	}
+	return "", false
+}
+
+func sortedEntryIDs(t *Taxonomy) []string {
+	ids := make([]string, 0, len(t.Entries))
+	for id := range t.Entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func addEntrySynonyms(synonyms map[string]string, entry *TaxonomyEntry, allergen string) {
+	for lang, names := range entry.Names {
+		for _, name := range names {
+			key := taxonomyKey(lang, name)
+			if _, exists := synonyms[key]; !exists {
+				synonyms[key] = allergen
+			}
+		}
+	}
+}
+
+// splitTaxonomyTag splits "fr:lait" into "fr" and "lait". Tags without a language prefix are treated as English.
+func splitTaxonomyTag(tag string) (string, string) {
+	parsed := ParseTag(tag)
+	if parsed.Lang == "" {
+		return "en", parsed.Value
+	}
+	return parsed.Lang, parsed.Value
+}
+
+// taxonomyKey builds the lookup key "lang:normalized-name" used for synonyms
+func taxonomyKey(lang string, name string) string {
+	return lang + ":" + normalizeTaxonomyName(name)
+}
+
+// normalizeTaxonomyName lowercases, removes accents and joins words with hyphens, like OFF tags
+func normalizeTaxonomyName(name string) string {
+	name = unaccentReplacer.Replace(strings.ToLower(strings.TrimSpace(name)))
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "-")
+}
+
+// splitTaxonomyNames splits a comma separated synonyms list, honoring escaped commas
+func splitTaxonomyNames(s string) []string {
+	s = strings.ReplaceAll(s, `\,`, "\x00")
+	names := []string{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(strings.ReplaceAll(name, "\x00", ","))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// isTaxonomyLanguage reports whether a prefix looks like a language code such as "en", "fr" or "zh_cn"
+func isTaxonomyLanguage(prefix string) bool {
+	if prefix == "xx" {
+		return true
+	}
+	lang, region, hasRegion := strings.Cut(prefix, "_")
+	if len(lang) < 2 || len(lang) > 3 {
+		return false
+	}
+	if hasRegion && (len(region) < 2 || len(region) > 3) {
+		return false
+	}
+	for _, r := range lang + region {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testAllergensTaxonomy = `# Allergens
+synonyms:en: nut, nuts
+
+en: Milk, dairy
+fr: Lait, produits laitiers
+de: Milch
+wikidata:en: Q8495
+
+en: Nuts, tree nuts
+fr: Fruits à coque
+
+< en:Nuts
+en: Hazelnuts
+fr: Noisettes
+`
+
+const testIngredientsTaxonomy = `en: Whey
+fr: Petit-lait, lactosérum
+allergens:en: en:milk
+
+en: Cashew nuts
+< en:Nuts
+`
+
+func TestParseTaxonomy(t *testing.T) {
+	taxonomy, err := ParseTaxonomy(strings.NewReader(testAllergensTaxonomy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(taxonomy.Entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(taxonomy.Entries))
+	}
+	milk := taxonomy.Entries["en:milk"]
+	if milk == nil {
+		t.Fatal("en:milk not parsed")
+	}
+	if !reflect.DeepEqual(milk.Names["fr"], []string{"Lait", "produits laitiers"}) {
+		t.Errorf("fr names: got %v", milk.Names["fr"])
+	}
+	if milk.Properties["wikidata:en"] != "Q8495" {
+		t.Errorf("wikidata:en: got %q", milk.Properties["wikidata:en"])
+	}
+
+	resolved := map[string]string{
+		"fr:lait":              "en:milk",
+		"fr:produits-laitiers": "en:milk",
+		"de:Milch":             "en:milk",
+		"fr:fruits-a-coque":    "en:nuts",
+		"en:tree nuts":         "en:nuts",
+		"Hazelnuts":            "en:hazelnuts",
+		"fr:Noisettes":         "en:hazelnuts",
+	}
+	for tag, want := range resolved {
+		if id, ok := taxonomy.Resolve(tag); !ok || id != want {
+			t.Errorf("Resolve(%q): got %q, want %q", tag, id, want)
+		}
+	}
+	if _, ok := taxonomy.Resolve("fr:pain"); ok {
+		t.Error("Resolve(fr:pain): unknown name resolved")
+	}
+
+	if ancestors := taxonomy.Ancestors("en:hazelnuts"); !reflect.DeepEqual(ancestors, []string{"en:nuts"}) {
+		t.Errorf("Ancestors(en:hazelnuts): got %v", ancestors)
+	}
+}
+
+func TestLookupAllergenTag(t *testing.T) {
+	allergens, err := ParseTaxonomy(strings.NewReader(testAllergensTaxonomy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingredients, err := ParseTaxonomy(strings.NewReader(testIngredientsTaxonomy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := AllergenSynonyms
+	defer func() { AllergenSynonyms = saved }()
+	AllergenSynonyms = BuildAllergenSynonyms(allergens, ingredients)
+	// A taxonomy name that would remap a standardized value must not win over AllergenMap
+	AllergenSynonyms["en:walnuts"] = "nuts"
+
+	tests := []struct {
+		tag  string
+		want string
+		ok   bool
+	}{
+		{"en:milk", "milk", true},
+		{"fr:lait", "milk", true},
+		{"de:milch", "milk", true},
+		{"fr:petit-lait", "milk", true},
+		{"fr:lactoserum", "milk", true},
+		{"en:walnuts", "walnuts", true},
+		{"fr:gluten", "gluten", true},
+		{"en:tree-nuts", "tree_nuts", true},
+		{"fr:pain", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := lookupAllergenTag(ParseTag(tt.tag))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("lookupAllergenTag(%q): got %q %v, want %q %v", tt.tag, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// servingcorpus adds the most frequent serving_size strings of an Open Food Facts export to the serving size test corpus.
+// Review the new strings, then regenerate the golden file with "go test -run TestParseServingSizeGolden -update".
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const INPUT_FILE = "input/openfoodfacts-products.jsonl.gz"
+const CORPUS_FILE = "testdata/serving_sizes.txt"
+
+type product struct {
+	ServingSize string `json:"serving_size"`
+	Lang        string `json:"lang"`
+}
+
+func main() {
+	input := flag.String("input", INPUT_FILE, "Open Food Facts JSONL export")
+	corpus := flag.String("corpus", CORPUS_FILE, "Corpus file the strings are appended to")
+	limit := flag.Int("limit", 5000, "Maximum number of new strings")
+	minCount := flag.Int("min-count", 2, "Minimum number of products using a string")
+	flag.Parse()
+
+	existing, err := readCorpus(*corpus)
+	if err != nil {
+		log.Fatalf("Failed to read corpus: %v", err)
+	}
+
+	counts, err := countServingSizes(*input)
+	if err != nil {
+		log.Fatalf("Failed to read input file: %v", err)
+	}
+
+	candidates := []string{}
+	for servingSize, count := range counts {
+		if count >= *minCount && !existing[servingSize] {
+			candidates = append(candidates, servingSize)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if counts[candidates[i]] != counts[candidates[j]] {
+			return counts[candidates[i]] > counts[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+	if len(candidates) > *limit {
+		candidates = candidates[:*limit]
+	}
+
+	file, err := os.OpenFile(*corpus, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open corpus: %v", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, servingSize := range candidates {
+		fmt.Fprintln(writer, servingSize)
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatalf("Failed to write corpus: %v", err)
+	}
+
+	log.Printf("Added %d serving sizes to %s", len(candidates), *corpus)
+}
+
+// countServingSizes returns how many products of the export use each serving_size string, as corpus lines.
+// Strings of products in another language than English are prefixed with the lang and a tab.
+func countServingSizes(path string) (map[string]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gzReader.Close()
+
+	counts := make(map[string]int)
+	decoder := json.NewDecoder(gzReader)
+	for {
+		var p product
+		err := decoder.Decode(&p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// The corpus has one string per line
+		servingSize := strings.Join(strings.Fields(p.ServingSize), " ")
+		if servingSize == "" || strings.HasPrefix(servingSize, "#") {
+			continue
+		}
+		if lang := strings.ToLower(strings.TrimSpace(p.Lang)); lang != "" && lang != "en" {
+			servingSize = lang + "\t" + servingSize
+		}
+		counts[servingSize]++
+	}
+
+	return counts, nil
+}
+
+func readCorpus(path string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return existing, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		existing[scanner.Text()] = true
+	}
+	return existing, scanner.Err()
+}
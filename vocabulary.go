@@ -0,0 +1,239 @@
+package main
+
+import (
+	"strings"
+)
+
+// Canonical unit IDs of serving size units
+const (
+	UnitGram       = "g"
+	UnitKilogram   = "kg"
+	UnitMilligram  = "mg"
+	UnitMilliliter = "ml"
+	UnitCentiliter = "cl"
+	UnitDeciliter  = "dl"
+	UnitLiter      = "l"
+	UnitOunce      = "oz"
+	UnitFluidOunce = "fl_oz"
+	UnitPound      = "lb"
+	UnitCup        = "cup"
+	UnitTablespoon = "tbsp"
+	UnitTeaspoon   = "tsp"
+	UnitSlice      = "slice"
+	UnitPiece      = "piece"
+	UnitPortion    = "portion"
+	UnitServing    = "serving"
+	UnitCookie     = "cookie"
+	UnitBar        = "bar"
+	UnitGlass      = "glass"
+	UnitBowl       = "bowl"
+	UnitBottle     = "bottle"
+	UnitCan        = "can"
+	UnitPot        = "pot"
+	UnitSachet     = "sachet"
+	UnitPouch      = "pouch"
+	UnitScoop      = "scoop"
+	UnitCapsule    = "capsule"
+	UnitTablet     = "tablet"
+	UnitPackage    = "package"
+)
+
+// ServingVocabulary maps the serving size words of each language, with their abbreviations and common typos,
+// to canonical unit IDs. English is used for every product, the vocabulary of the product lang is checked first.
+var ServingVocabulary = map[string]map[string]string{
+	"en": {
+		"g": UnitGram, "gr": UnitGram, "grm": UnitGram, "grs": UnitGram, "gm": UnitGram, "gms": UnitGram, "gram": UnitGram, "grams": UnitGram,
+		"kg": UnitKilogram, "kgs": UnitKilogram, "kilogram": UnitKilogram, "kilograms": UnitKilogram,
+		"mg": UnitMilligram, "milligram": UnitMilligram, "milligrams": UnitMilligram,
+		"ml": UnitMilliliter, "mls": UnitMilliliter, "milliliter": UnitMilliliter, "millilitre": UnitMilliliter, "milliliters": UnitMilliliter, "millilitres": UnitMilliliter,
+		"cl": UnitCentiliter, "dl": UnitDeciliter,
+		"l": UnitLiter, "lt": UnitLiter, "ltr": UnitLiter, "liter": UnitLiter, "litre": UnitLiter, "liters": UnitLiter, "litres": UnitLiter,
+		"oz": UnitOunce, "ozs": UnitOunce, "oza": UnitOunce, "ozn": UnitOunce, "onz": UnitOunce, "ounce": UnitOunce, "ounces": UnitOunce,
+		"fl oz": UnitFluidOunce, "floz": UnitFluidOunce, "fl ozs": UnitFluidOunce, "fluid oz": UnitFluidOunce, "fluid ounce": UnitFluidOunce, "fluid ounces": UnitFluidOunce,
+		"lb": UnitPound, "lbs": UnitPound, "pound": UnitPound, "pounds": UnitPound,
+		"cup": UnitCup, "cups": UnitCup,
+		"tbsp": UnitTablespoon, "tbsps": UnitTablespoon, "tbs": UnitTablespoon, "tbl": UnitTablespoon, "tblsp": UnitTablespoon, "tablespoon": UnitTablespoon, "tablespoons": UnitTablespoon,
+		"tsp": UnitTeaspoon, "tsps": UnitTeaspoon, "teaspoon": UnitTeaspoon, "teaspoons": UnitTeaspoon,
+		"slice": UnitSlice, "slices": UnitSlice,
+		"piece": UnitPiece, "pieces": UnitPiece, "pc": UnitPiece, "pcs": UnitPiece,
+		"portion": UnitPortion, "portions": UnitPortion,
+		"serving": UnitServing, "servings": UnitServing,
+		"cookie": UnitCookie, "cookies": UnitCookie, "biscuit": UnitCookie, "biscuits": UnitCookie,
+		"bar": UnitBar, "bars": UnitBar,
+		"glass": UnitGlass, "glasses": UnitGlass,
+		"bowl": UnitBowl, "bowls": UnitBowl,
+		"bottle": UnitBottle, "bottles": UnitBottle,
+		"can": UnitCan, "cans": UnitCan,
+		"pot": UnitPot, "pots": UnitPot,
+		"sachet": UnitSachet, "sachets": UnitSachet, "packet": UnitSachet, "packets": UnitSachet,
+		"pouch": UnitPouch, "pouches": UnitPouch,
+		"scoop": UnitScoop, "scoops": UnitScoop,
+		"capsule": UnitCapsule, "capsules": UnitCapsule,
+		"tablet": UnitTablet, "tablets": UnitTablet,
+		"package": UnitPackage, "packages": UnitPackage, "pack": UnitPackage, "packs": UnitPackage,
+	},
+	"fr": {
+		"gramme": UnitGram, "grammes": UnitGram,
+		"cuillère à soupe": UnitTablespoon, "cuillères à soupe": UnitTablespoon, "c à soupe": UnitTablespoon, "c à s": UnitTablespoon, "cs": UnitTablespoon, "càs": UnitTablespoon, "cas": UnitTablespoon,
+		"cuillère à café": UnitTeaspoon, "cuillères à café": UnitTeaspoon, "c à café": UnitTeaspoon, "c à c": UnitTeaspoon, "cc": UnitTeaspoon, "càc": UnitTeaspoon, "cac": UnitTeaspoon,
+		"tasse": UnitCup, "tasses": UnitCup,
+		"tranche": UnitSlice, "tranches": UnitSlice,
+		"morceau": UnitPiece, "morceaux": UnitPiece, "pièce": UnitPiece, "pièces": UnitPiece, "unité": UnitPiece, "unités": UnitPiece,
+		"part": UnitPortion, "parts": UnitPortion,
+		"biscuit": UnitCookie, "biscuits": UnitCookie,
+		"barre": UnitBar, "barres": UnitBar,
+		"verre": UnitGlass, "verres": UnitGlass,
+		"bol": UnitBowl, "bols": UnitBowl,
+		"bouteille": UnitBottle, "bouteilles": UnitBottle,
+		"canette": UnitCan, "canettes": UnitCan,
+		"dose": UnitScoop, "doses": UnitScoop,
+		"gélule": UnitCapsule, "gélules": UnitCapsule,
+		"comprimé": UnitTablet, "comprimés": UnitTablet,
+		"paquet": UnitPackage, "paquets": UnitPackage,
+	},
+	"de": {
+		"gramm": UnitGram,
+		"el":    UnitTablespoon, "esslöffel": UnitTablespoon,
+		"tl": UnitTeaspoon, "teelöffel": UnitTeaspoon,
+		"tasse": UnitCup, "tassen": UnitCup,
+		"scheibe": UnitSlice, "scheiben": UnitSlice,
+		"stück": UnitPiece, "stk": UnitPiece, "stücke": UnitPiece,
+		"portionen": UnitPortion,
+		"keks":      UnitCookie, "kekse": UnitCookie,
+		"riegel": UnitBar,
+		"glas":   UnitGlass, "gläser": UnitGlass,
+		"schale": UnitBowl, "schüssel": UnitBowl,
+		"flasche": UnitBottle, "flaschen": UnitBottle,
+		"dose": UnitCan, "dosen": UnitCan,
+		"becher": UnitPot,
+		"beutel": UnitSachet, "tütchen": UnitSachet,
+		"messlöffel": UnitScoop,
+		"kapsel":     UnitCapsule, "kapseln": UnitCapsule,
+		"tablette": UnitTablet, "tabletten": UnitTablet,
+		"packung": UnitPackage, "packungen": UnitPackage,
+	},
+	"es": {
+		"gramo": UnitGram, "gramos": UnitGram,
+		"litro": UnitLiter, "litros": UnitLiter,
+		"onza": UnitOunce, "onzas": UnitOunce,
+		"cucharada": UnitTablespoon, "cucharadas": UnitTablespoon,
+		"cucharadita": UnitTeaspoon, "cucharaditas": UnitTeaspoon,
+		"taza": UnitCup, "tazas": UnitCup,
+		"rebanada": UnitSlice, "rebanadas": UnitSlice, "loncha": UnitSlice, "lonchas": UnitSlice,
+		"pieza": UnitPiece, "piezas": UnitPiece, "unidad": UnitPiece, "unidades": UnitPiece,
+		"porción": UnitPortion, "porciones": UnitPortion, "ración": UnitPortion, "raciones": UnitPortion,
+		"galleta": UnitCookie, "galletas": UnitCookie,
+		"barrita": UnitBar, "barritas": UnitBar,
+		"vaso": UnitGlass, "vasos": UnitGlass,
+		"tazón":   UnitBowl,
+		"botella": UnitBottle, "botellas": UnitBottle,
+		"lata": UnitCan, "latas": UnitCan,
+		"sobre": UnitSachet, "sobres": UnitSachet,
+		"cacito":  UnitScoop,
+		"cápsula": UnitCapsule, "cápsulas": UnitCapsule,
+		"comprimido": UnitTablet, "comprimidos": UnitTablet,
+		"paquete": UnitPackage, "paquetes": UnitPackage, "envase": UnitPackage,
+	},
+	"it": {
+		"grammo": UnitGram, "grammi": UnitGram,
+		"litro": UnitLiter, "litri": UnitLiter,
+		"cucchiaio": UnitTablespoon, "cucchiai": UnitTablespoon,
+		"cucchiaino": UnitTeaspoon, "cucchiaini": UnitTeaspoon,
+		"tazza": UnitCup, "tazze": UnitCup,
+		"fetta": UnitSlice, "fette": UnitSlice,
+		"pezzo": UnitPiece, "pezzi": UnitPiece,
+		"porzione": UnitPortion, "porzioni": UnitPortion,
+		"biscotto": UnitCookie, "biscotti": UnitCookie,
+		"barretta": UnitBar, "barrette": UnitBar,
+		"bicchiere": UnitGlass, "bicchieri": UnitGlass,
+		"ciotola":   UnitBowl,
+		"bottiglia": UnitBottle, "bottiglie": UnitBottle,
+		"lattina": UnitCan, "lattine": UnitCan,
+		"vasetto": UnitPot, "vasetti": UnitPot,
+		"bustina": UnitSachet, "bustine": UnitSachet,
+		"misurino": UnitScoop,
+		"capsula":  UnitCapsule, "capsule": UnitCapsule,
+		"compressa": UnitTablet, "compresse": UnitTablet,
+		"confezione": UnitPackage, "confezioni": UnitPackage,
+	},
+}
+
+// UnitNames are the English display forms of a canonical unit, e.g. "1 slice" and "2 slices"
+type UnitNames struct {
+	Singular string
+	Plural   string
+}
+
+// UnitDisplayNames lists the display forms of every canonical unit ID
+var UnitDisplayNames = map[string]UnitNames{
+	UnitGram:       {"g", "g"},
+	UnitKilogram:   {"kg", "kg"},
+	UnitMilligram:  {"mg", "mg"},
+	UnitMilliliter: {"ml", "ml"},
+	UnitCentiliter: {"cl", "cl"},
+	UnitDeciliter:  {"dl", "dl"},
+	UnitLiter:      {"l", "l"},
+	UnitOunce:      {"oz", "oz"},
+	UnitFluidOunce: {"fl oz", "fl oz"},
+	UnitPound:      {"lb", "lb"},
+	UnitCup:        {"cup", "cups"},
+	UnitTablespoon: {"tbsp", "tbsp"},
+	UnitTeaspoon:   {"tsp", "tsp"},
+	UnitSlice:      {"slice", "slices"},
+	UnitPiece:      {"piece", "pieces"},
+	UnitPortion:    {"portion", "portions"},
+	UnitServing:    {"serving", "servings"},
+	UnitCookie:     {"cookie", "cookies"},
+	UnitBar:        {"bar", "bars"},
+	UnitGlass:      {"glass", "glasses"},
+	UnitBowl:       {"bowl", "bowls"},
+	UnitBottle:     {"bottle", "bottles"},
+	UnitCan:        {"can", "cans"},
+	UnitPot:        {"pot", "pots"},
+	UnitSachet:     {"sachet", "sachets"},
+	UnitPouch:      {"pouch", "pouches"},
+	UnitScoop:      {"scoop", "scoops"},
+	UnitCapsule:    {"capsule", "capsules"},
+	UnitTablet:     {"tablet", "tablets"},
+	UnitPackage:    {"package", "packages"},
+}
+
+// unitDisplayName returns the singular display form of a unit ID for a quantity of 1 and the plural otherwise
+func unitDisplayName(unitID string, quantity float64) (string, bool) {
+	names, ok := UnitDisplayNames[unitID]
+	if !ok {
+		return "", false
+	}
+	if quantity == 1 {
+		return names.Singular, true
+	}
+	return names.Plural, true
+}
+
+// servingVocabularyIndex is ServingVocabulary with normalized words, built on first use
+var servingVocabularyIndex map[string]map[string]string
+
+// servingUnitID returns the canonical unit ID of a serving size word in the product language or English
+func servingUnitID(unit string, lang string) (string, bool) {
+	if servingVocabularyIndex == nil {
+		servingVocabularyIndex = make(map[string]map[string]string, len(ServingVocabulary))
+		for vocabularyLang, words := range ServingVocabulary {
+			index := make(map[string]string, len(words))
+			for word, id := range words {
+				index[normalizeTaxonomyName(word)] = id
+			}
+			servingVocabularyIndex[vocabularyLang] = index
+		}
+	}
+
+	word := normalizeTaxonomyName(unit)
+	if word == "" {
+		return "", false
+	}
+	for _, vocabularyLang := range []string{strings.ToLower(lang), "en"} {
+		if id, ok := servingVocabularyIndex[vocabularyLang][word]; ok {
+			return id, true
+		}
+	}
+	return "", false
+}